@@ -0,0 +1,162 @@
+package main
+
+import (
+	"math/bits"
+
+	"github.com/notnil/chess"
+)
+
+/*
+
+Bitboards.
+
+One bit per square, a1 is bit 0 and h8 bit 63 like chess.Square. Knight,
+king and pawn attacks come from tables. Sliding attacks use a table of rays
+per direction: the first blocker on a ray is found with a bit scan and the
+ray behind it is cut off again, so no magic numbers or PEXT are needed.
+
+*/
+
+type Bitboard uint64
+
+const FILE_A Bitboard = 0x0101010101010101
+const FILE_H Bitboard = FILE_A << 7
+const RANK_1 Bitboard = 0xff
+const RANK_2 Bitboard = RANK_1 << 8
+const RANK_7 Bitboard = RANK_1 << 48
+const RANK_8 Bitboard = RANK_1 << 56
+const DARK_SQUARES Bitboard = 0xaa55aa55aa55aa55
+
+var knight_jumps = [8][2]int{{1, 2}, {2, 1}, {2, -1}, {1, -2}, {-1, -2}, {-2, -1}, {-2, 1}, {-1, 2}}
+var king_steps = [8][2]int{{1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}, {0, -1}, {1, -1}}
+
+// ray directions, the first four go up the board (towards higher squares)
+var ray_steps = [8][2]int{{0, 1}, {1, 0}, {1, 1}, {-1, 1}, {0, -1}, {-1, 0}, {1, -1}, {-1, -1}}
+
+const (
+	NORTH = iota
+	EAST
+	NORTH_EAST
+	NORTH_WEST
+	SOUTH
+	WEST
+	SOUTH_EAST
+	SOUTH_WEST
+)
+
+var knight_attacks = step_attacks(knight_jumps[:])
+var king_attacks = step_attacks(king_steps[:])
+var pawn_attacks = [2][64]Bitboard{ // by side_index
+	step_attacks([][2]int{{-1, 1}, {1, 1}}),
+	step_attacks([][2]int{{-1, -1}, {1, -1}}),
+}
+var rays = build_rays()
+
+func square_bb(sq chess.Square) Bitboard {
+	return 1 << uint(sq)
+}
+
+// the square of a file and rank, false if either is off the board
+func square_at(file int, rank int) (chess.Square, bool) {
+	if file < 0 || file > 7 || rank < 0 || rank > 7 {
+		return chess.NoSquare, false
+	}
+	return chess.Square(rank*8 + file), true
+}
+
+func (b Bitboard) lsb() chess.Square {
+	return chess.Square(bits.TrailingZeros64(uint64(b)))
+}
+
+func (b Bitboard) msb() chess.Square {
+	return chess.Square(63 - bits.LeadingZeros64(uint64(b)))
+}
+
+// removes the lowest square from the set and returns it
+func (b *Bitboard) pop() chess.Square {
+	sq := b.lsb()
+	*b &= *b - 1
+	return sq
+}
+
+// the squares from a to b on a rank, both included
+func rank_span(a chess.Square, b chess.Square) Bitboard {
+	if a > b {
+		a, b = b, a
+	}
+	return (square_bb(b) - square_bb(a)) | square_bb(b)
+}
+
+func (b Bitboard) count() int {
+	return bits.OnesCount64(uint64(b))
+}
+
+func step_attacks(steps [][2]int) (table [64]Bitboard) {
+	for sq := 0; sq < 64; sq++ {
+		for _, step := range steps {
+			if to, ok := square_at(sq%8+step[0], sq/8+step[1]); ok {
+				table[sq] |= square_bb(to)
+			}
+		}
+	}
+	return
+}
+
+// every square from a square to the edge of the board in each direction
+func build_rays() (table [8][64]Bitboard) {
+	for dir, step := range ray_steps {
+		for sq := 0; sq < 64; sq++ {
+			file, rank := sq%8+step[0], sq/8+step[1]
+			for {
+				to, ok := square_at(file, rank)
+				if !ok {
+					break
+				}
+				table[dir][sq] |= square_bb(to)
+				file, rank = file+step[0], rank+step[1]
+			}
+		}
+	}
+	return
+}
+
+func ray_attacks(sq chess.Square, occupied Bitboard, dir int) Bitboard {
+	attacks := rays[dir][sq]
+	if blockers := attacks & occupied; blockers != 0 {
+		blocker := blockers.lsb()
+		if dir >= SOUTH {
+			blocker = blockers.msb()
+		}
+		attacks ^= rays[dir][blocker]
+	}
+	return attacks
+}
+
+func bishop_attacks(sq chess.Square, occupied Bitboard) Bitboard {
+	return ray_attacks(sq, occupied, NORTH_EAST) | ray_attacks(sq, occupied, NORTH_WEST) |
+		ray_attacks(sq, occupied, SOUTH_EAST) | ray_attacks(sq, occupied, SOUTH_WEST)
+}
+
+func rook_attacks(sq chess.Square, occupied Bitboard) Bitboard {
+	return ray_attacks(sq, occupied, NORTH) | ray_attacks(sq, occupied, EAST) |
+		ray_attacks(sq, occupied, SOUTH) | ray_attacks(sq, occupied, WEST)
+}
+
+// squares a piece of the type attacks from a square, pawns need their color
+func piece_attacks(p chess.PieceType, color chess.Color, sq chess.Square, occupied Bitboard) Bitboard {
+	switch p {
+	case chess.Pawn:
+		return pawn_attacks[side_index(color)][sq]
+	case chess.Knight:
+		return knight_attacks[sq]
+	case chess.Bishop:
+		return bishop_attacks(sq, occupied)
+	case chess.Rook:
+		return rook_attacks(sq, occupied)
+	case chess.Queen:
+		return bishop_attacks(sq, occupied) | rook_attacks(sq, occupied)
+	case chess.King:
+		return king_attacks[sq]
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+)
+
+/*
+
+Chess960.
+
+The 960 start positions are numbered like Scharnagl does: the index picks the
+light squared bishop, the dark squared bishop, the queen and the two knights in
+turn, the rooks and king fill the three squares left in that order, so the king
+always stands between its rooks. Number 518 is the normal start position.
+
+The position and search need nothing special, castling is stored as the king
+taking its own rook for every game. With UCI_Chess960 set the frontend also
+reads and writes castles that way, keeps the game on its own Position since
+notnil/chess can't castle in Chess960, and never plays from the opening book.
+
+*/
+
+var CHESS960 bool = false // set with the UCI_Chess960 option
+
+// the two knights on the five squares left, by the last digit of the index
+var knight_placements = [10][2]int{{0, 1}, {0, 2}, {0, 3}, {0, 4}, {1, 2}, {1, 3}, {1, 4}, {2, 3}, {2, 4}, {3, 4}}
+
+// the back rank of start position 0 to 959, white pieces from a to h
+func chess960_rank(index int) string {
+	var rank [8]byte
+	n := index
+	rank[2*(n%4)+1] = 'B'
+	n /= 4
+	rank[2*(n%4)] = 'B'
+	n /= 4
+	place(&rank, n%6, 'Q')
+	n /= 6
+	knights := knight_placements[n%10]
+	place(&rank, knights[1], 'N') // the later square first, the earlier one doesn't move
+	place(&rank, knights[0], 'N')
+	for _, piece := range []byte{'R', 'K', 'R'} {
+		place(&rank, 0, piece)
+	}
+	return string(rank[:])
+}
+
+// puts the piece on the nth empty square of the rank
+func place(rank *[8]byte, n int, piece byte) {
+	for i := range rank {
+		if rank[i] != 0 {
+			continue
+		}
+		if n == 0 {
+			rank[i] = piece
+			return
+		}
+		n--
+	}
+}
+
+// fen of start position 0 to 959, castling rights in X-FEN
+func chess960_fen(index int) string {
+	white := chess960_rank(index)
+	return strings.ToLower(white) + "/pppppppp/8/8/8/8/PPPPPPPP/" + white + " w KQkq - 0 1"
+}
@@ -12,6 +12,7 @@ Flag
 2: Alpha Beta (Move Ordering Bool)
 3: Quiescence
 4: Hashing
+5: Principal Variation Search (Hashing with null windows)
 
 Settings:
 Iterative Deepening (sets Default Depth to 1)
@@ -27,14 +28,54 @@ const DO_MTDF bool = false // forces iterative deepening
 
 const DO_MOVE_ORDERING bool = true
 const DO_ITERATIVE_DEEPENING bool = true
-const DO_STRICT_TIMING bool = false
+const DO_STRICT_TIMING bool = true // abort the running iteration when the clock runs out
 const MAX_ITERATIVE_DEPTH int = 12
 const TIME_TO_THINK int = 3
 const MAX_MOVES = 200
 const MAX_QUIESCENCE = -1000
 
+const DO_NULL_MOVE bool = true       // null move pruning
+const DO_NULL_VERIFY bool = true     // re-search null move cutoffs at high depths
+const NULL_MOVE_R int = 2            // depth reduction of the null move search
+const NULL_MOVE_MIN_DEPTH int = 3    // no null move below this depth
+const NULL_VERIFY_DEPTH int = 6      // verify null move cutoffs from this depth on
+
+const DO_LMR bool = true      // late move reductions
+const LMR_MIN_DEPTH int = 3   // no reductions below this depth
+const LMR_MIN_MOVES int = 3   // the first moves of the ordered list are never reduced
+
+const DO_SEE_PRUNING bool = true // skip captures that lose material in quiescence
+
+const DO_MATE_DISTANCE_PRUNING bool = true // cut lines that can't beat a mate already found
+
+const DO_ZOBRIST_CHECK bool = false // debug: compare every incremental key with one computed from scratch
+
+const DO_CHECK_EXTENSION bool = true     // search checking moves one ply deeper
+const DO_ONE_REPLY_EXTENSION bool = true // same for the only legal move
+const DO_QUIESCENCE_CHECKS bool = true   // quiet checks in the first ply of quiescence
+const DO_QUIESCENCE_EVASIONS bool = true // all moves out of check in quiescence
+
+const DO_REVERSE_FUTILITY bool = true   // static null move pruning
+const REVERSE_FUTILITY_DEPTH int = 3     // only this close to the horizon
+const REVERSE_FUTILITY_MARGIN int = 120  // per ply of depth left
+const DO_RAZORING bool = true            // drop into quiescence when far below alpha
+const RAZOR_DEPTH int = 2
+const RAZOR_MARGIN int = 300             // per ply of depth left
+const DO_FUTILITY bool = true            // skip quiet moves at frontier nodes far below alpha
+const FUTILITY_DEPTH int = 2
+const FUTILITY_MARGIN int = 150          // per ply of depth left
+const DO_DELTA bool = true               // skip quiescence captures that can't reach alpha
+const DELTA_MARGIN int = 200
+
+const DO_ASPIRATION bool = true         // aspiration windows in iterative deepening
+const ASPIRATION_WINDOW int = 25        // initial half width around the previous score
+const ASPIRATION_MAX_WINDOW int = 1000  // past this the window opens completely
+
 var VERBOSE_FLAG = 3
 
+var depth_limit = MAX_ITERATIVE_DEPTH
+var node_limit = 0 // 0 means no limit
+
 var DEPTH int = 3       // default value without iterative deepening
 const mem_size int = 40 // limits max depth
 const MAX_DEPTH int = (mem_size - 1)
@@ -44,10 +85,11 @@ var hash_count int = 0
 var hash_write_count int = 0
 var hash_count_list = [3]int{0, 0, 0}
 var explored_depth [mem_size]int
-var position_eval = 0
 var move_count = 1 // just for display
 var engine_color = chess.White
-var delay time.Time
+var delay int64 // hard deadline of the running search in unix nanoseconds, set atomically
+var stop_search int32 = 0 // set atomically when the running search has to be aborted
+var search_start time.Time
 var opening_moves bool = true // always should be true
 var default_start *chess.Move
 
@@ -85,6 +127,14 @@ func Max(x, y int) int {
     return x
 }
 
+// Min returns the smaller of x or y.
+func Min(x, y int) int {
+    if x > y {
+        return y
+    }
+    return x
+}
+
 
 // code storage
 
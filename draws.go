@@ -0,0 +1,74 @@
+package main
+
+/*
+
+Draws inside the search tree.
+
+Every thread keeps a stack with the Zobrist key of each position of the game
+and of the current search path. A position seen before since the last capture
+or pawn move is a draw: once is enough inside the search path since the side
+to move can always repeat it, before the root it takes two like the threefold
+rule. The fifty move counter is carried along the same stack.
+
+Draws score CONTEMPT below equal for the engine, and above for the opponent.
+
+*/
+
+var CONTEMPT int = 0 // set with the Contempt option, positive avoids draws
+
+type path_entry struct {
+	key   uint64
+	fifty int // plies since the last capture or pawn move
+}
+
+// copies the root into the thread's position and fills the stack with the
+// game that led to it, the root is pushed by the search
+func (t *Thread) setup_path(root *Position) {
+	t.pos = root.copy()
+	t.path = t.path[:0]
+	t.barrier = 0
+	for _, entry := range root.history {
+		t.path = append(t.path, path_entry{entry.key, entry.fifty})
+	}
+	t.root_side = t.pos.turn
+	t.root_index = len(t.path)
+	t.root_fifty = t.pos.fifty
+}
+
+// true if the node is drawn by the fifty move rule or a repetition
+func (t *Thread) path_draw(key uint64, fifty int) bool {
+	if fifty >= 100 {
+		return true
+	}
+	n := len(t.path) // where the node goes, the same side to move is two back
+	count := 0
+	for i := n - 2; i >= t.barrier && i >= n-fifty; i -= 2 {
+		if t.path[i].key != key {
+			continue
+		}
+		if i >= t.root_index {
+			return true
+		}
+		count++
+		if count >= 2 {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Thread) push_path(key uint64, fifty int) {
+	t.path = append(t.path, path_entry{key, fifty})
+}
+
+func (t *Thread) pop_path() {
+	t.path = t.path[:len(t.path)-1]
+}
+
+// draw score for the side to move
+func (t *Thread) draw_score(pos *Position) int {
+	if pos.turn == t.root_side {
+		return -CONTEMPT
+	}
+	return CONTEMPT
+}
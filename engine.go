@@ -3,9 +3,7 @@ package main
 import (
 	"fmt"
 	"math"
-	"sort"
-	"strconv"
-	"time"
+	"sync/atomic"
 
 	"github.com/notnil/chess"
 )
@@ -14,352 +12,322 @@ import (
 
 Todo:
 
-Fix Hashing and Strict Timing
-Parralelization
-Null Window Search (AKA Negascout/PVS)
+Fix Hashing
 
 Improve evaluation function.
 	Center Control, Isolation, King Safety, Mobility
 Endgames?
 
 To fix:
-	En passant and castling in zobrist
 	Draw detection
 	King endgame table
-	Strict timing is still broken
 
 */
 
-var nullMove chess.Move = chess.Move{}
-
-
-func minimax_hashing(game *chess.Game, depth int, alpha int, beta int, max bool, preval int) (best *chess.Move, eval int, history [mem_size]string, ignore bool) {
-	index_depth := DEPTH - depth
-	explored++
-	explored_depth[index_depth]++
-
-	if check_time_up() {
-		// if it's top level send it back no matter what
-		if index_depth == 1 {
-			flag, hashscore, hashbest, _, depthfound := read_hash(zobrist(game.Position().Board(), max), int(math.Inf(-1)), int(math.Inf(-1)), int(math.Inf(1)))
-			if flag == DeeperResult || flag == QuiescenceDeeperResult {
-				if hashbest != nil {
-					history[index_depth] = hashbest.String() + "- timeup top hashed" + strconv.Itoa(depthfound)
-				} else {
-					history[index_depth] = "timeup top hashed edge"
-				}
-				return hashbest, hashscore, history, false
-			} else {
-				history[index_depth] = "null time (top)"
-				return nil, 0, history, true // don't use this calculation
-			}
-		}
-		flag, hashscore, hashbest, _, depthfound := read_hash(zobrist(game.Position().Board(), max), depth, int(math.Inf(-1)), int(math.Inf(1)))
-		if flag == DeeperResult || flag == QuiescenceDeeperResult {
-			if hashbest != nil {
-				history[index_depth] = hashbest.String() + "- timeup hashed" + strconv.Itoa(depthfound)
-			} else {
-				history[index_depth] = "timeup hashed edge"
-			}
-			return hashbest, hashscore, history, false
-		} else {
-			history[index_depth] = "null time"
-			return nil, 0, history, true // don't use this calculation
-		}
+// Negamax search: every node maximizes its own score, scores are relative to
+// the side to move and flipped between plies. preval stays white relative
+// since that's what evaluate_position updates incrementally.
+func (t *Thread) minimax_hashing(pos *Position, depth int, alpha int, beta int, preval int) (best Move, eval int, ignore bool) {
+	index_depth := t.ply
+	atomic.AddInt64(&t.explored, 1)
+	t.explored_depth[index_depth]++
+	t.clear_pv(index_depth)
+
+	// the search was aborted, unwind without using any of this iteration
+	if t.check_time_up() {
+		return NO_MOVE, 0, true
 	}
 
+	hash := pos.key
 	if depth <= MAX_QUIESCENCE || index_depth >= MAX_DEPTH {
-		return end_at_edge(game, depth, max, preval)
+		return t.end_at_edge(pos, hash, depth, preval)
 	}
 
-	flag, hashscore, hashbest, hashmoves, depthfound := read_hash(zobrist(game.Position().Board(), max), depth, alpha, beta)
+	// repetitions, the fifty move rule and dead positions, the table doesn't know the path
+	fifty := pos.fifty
+	if index_depth > 0 && (t.path_draw(hash, fifty) || pos.insufficient_material()) {
+		return NO_MOVE, t.draw_score(pos), false
+	}
 
-	if flag == DeeperResult {
-		if hashbest != nil {
-			history[index_depth] = hashbest.String() + "-hdeeper"
-		} else {
-			history[index_depth] = "edge deeper"
+	// mate distance pruning: nothing from here beats a mate found closer to the root
+	if index_depth > 0 && DO_MATE_DISTANCE_PRUNING {
+		alpha = Max(alpha, mated_in(index_depth))
+		beta = Min(beta, -mated_in(index_depth+1))
+		if alpha >= beta {
+			return NO_MOVE, alpha, false
 		}
-		return hashbest, hashscore, history, false
 	}
 
-	var moves []*chess.Move
-	if depth <= 0 {
-		if flag == QuiescenceDeeperResult {
-			history[index_depth] = hashbest.String() + "-qdeeper"
-			return hashbest, hashscore, history, false
-		} else if flag == QuiescenceSavedMoves {
-			moves = hashmoves
+	flag, hashscore, hashmove, depthfound := t.read_hash(hash, depth, index_depth, alpha, beta)
+
+	// the root is always searched, aspiration re-searches need a real result
+	if flag == DeeperResult && index_depth > 0 {
+		hashbest := pos.pseudo_legal(hashmove)
+		if hashbest != NO_MOVE && pos.legal(hashbest) {
+			// no child was searched, the line comes from the table
+			t.hash_pv(pos, index_depth, hashbest)
 		} else {
-			if flag == MinimaxSavedMoves {
-				moves = hashmoves
-			} else {
-				moves = game.ValidMoves()
-			}
-			moves = get_quiescence_moves(game, moves)
+			hashbest = NO_MOVE
 		}
+		return hashbest, hashscore, false
+	}
 
-		if len(moves) == 0 { // if quiet
-			return end_at_edge(game, depth, max, preval)
-		} else { // not quiet
-			return quiescence_hashing(game, depth, alpha, beta, max, preval, moves)
+	if depth <= 0 {
+		// standing pat is no way out of a mate, only nodes in check need the test
+		if pos.in_check() && !pos.has_legal_move() {
+			return NO_MOVE, mated_in(index_depth), false
 		}
+		t.push_path(hash, fifty)
+		defer t.pop_path()
+		return t.quiescence_hashing(pos, hash, depth, alpha, beta, preval, hashmove)
 	}
 
-	if flag == MinimaxSavedMoves {
-		moves = hashmoves
-	} else {
-		moves = game.ValidMoves()
+	// hopeless or overwhelming static scores near the horizon
+	if index_depth > 0 && reverse_futility(pos, depth, beta, preval) {
+		return NO_MOVE, beta, false
+	}
+	if index_depth > 0 {
+		if cutoff, razor, ignore := t.razoring(pos, depth, alpha, beta, preval); ignore {
+			return NO_MOVE, 0, true
+		} else if cutoff {
+			return NO_MOVE, razor, false
+		}
 	}
 
-	if len(moves) == 0 {
-		return end_at_edge(game, depth, max, preval)
+	// razoring searched this node on its own, everything from here on is below it
+	t.push_path(hash, fifty)
+	defer t.pop_path()
+
+	// give the opponent a free move, if we're still above beta this node isn't worth searching
+	if cutoff, ignore := t.null_move_pruning(pos, hash, depth, beta, preval); ignore {
+		return NO_MOVE, 0, true
+	} else if cutoff {
+		t.clear_pv(index_depth) // the verification search may have left a line
+		return NO_MOVE, beta, false
 	}
 
-	if flag != 2 {
-		moves = move_order(game, moves)
+	// counting the moves everywhere would undo the staged generation, the
+	// only move out of check is the one reply worth extending
+	single := false
+	if DO_ONE_REPLY_EXTENSION && pos.in_check() {
+		single = len(pos.legal_moves(t.moves[index_depth][:0])) == 1
 	}
 
-	root := index_depth == 0
+	root := index_depth == 0 && t.id == 0
 
 	if root && VERBOSE_FLAG == 2 {
 		fmt.Println("\nDEPTH:", depth, preval)
 		// fmt.Println("MOVE ORDER:\n", moves)
-		fmt.Println("HASH RETURN:\n", depthfound, hashscore, "\n")
-		// hashbest, hashmoves, flag
-		// fmt.Println(hash_map[zobrist(game.Position().Board(), max)])
+		fmt.Print("HASH RETURN:\n ", depthfound, " ", hashscore, "\n\n")
+		// hashmove, flag
 	}
 
-	return minimax_hashing_core(game, depth, alpha, beta, max, preval, moves)
+	return t.minimax_hashing_core(pos, hash, depth, alpha, beta, preval, hashmove, single)
 }
 
-func minimax_hashing_core(game *chess.Game, depth int, alpha int, beta int, max bool, preval int, moves []*chess.Move) (best *chess.Move, eval int, history [mem_size]string, ignore bool) {
-	root := depth == DEPTH
-	index_depth := DEPTH - depth
-	move_sorting := make(map[*chess.Move]int)
-
-	if max {
-		eval = math.MinInt
-		for _, move := range moves {
-
-			// create a new game and simulate the move
-			post := game.Clone()
-			post.Move(move)
-
-			// evaluate the position relatively (take current eval and take difference)
-			state_eval := evaluate_position(game, post, preval, move)
-
-			// search one depth further
-			_, tempeval, temphistory, ignore := minimax_hashing(post, depth-1, alpha, beta, !max, state_eval)
-
-			// save each move value
-			move_sorting[move] = tempeval
-
-			// this move wasn't evaluated due to time
-			if ignore {
-				continue
-			}
-
-			// save if better than previous move
-			if tempeval > eval {
-				eval = tempeval
-				best = move
-				temphistory[index_depth] = move.String()
-				history = temphistory
-				print_root_move_1(root, post, move, tempeval, beta, history)
-			} else {
-				print_root_move_2(root)
-			}
-
-			// set alpha is better than alpha
-			if tempeval > alpha {
-				alpha = tempeval
-			}
-
-			// checkmate for white
-			if tempeval >= 1000000 {
-				break
-			}
-
-			// there exists a preferrable path elsewhere that is always better for me
-			// my opponent has an option in this branch that i can't avoid, not looking anymore
-			if alpha >= beta {
-				break
-			}
+// searches the moves of a node, single is set if there's only one
+func (t *Thread) minimax_hashing_core(pos *Position, hash uint64, depth int, alpha int, beta int, preval int, hashmove uint16, single bool) (best Move, eval int, ignore bool) {
+	root := t.ply == 0 && t.id == 0 // only the main thread prints
+	index_depth := t.ply
+	original_alpha := alpha
+
+	eval = -math.MaxInt
+	picker := t.new_picker(pos, index_depth, hashmove, true, false)
+	searched := 0 // legal moves
+	for move := picker.next(); move != NO_MOVE; move = picker.next() {
+		check := pos.gives_check(move)
+
+		// quiet moves that can't reach alpha
+		if futility_prune(pos, move, check, depth, alpha, preval, best != NO_MOVE) {
+			continue
 		}
-	} else {
-		eval = math.MaxInt
-		for _, move := range moves {
-
-			// create a new game and simulate the move
-			post := game.Clone()
-			post.Move(move)
-
-			// evaluate the position relatively (take current eval and take difference)
-			state_eval := evaluate_position(game, post, preval, move)
-
-			// search one depth further
-			_, tempeval, temphistory, ignore := minimax_hashing(post, depth-1, alpha, beta, !max, state_eval)
-
-			// save each move value
-			move_sorting[move] = tempeval
-
-			// this move wasn't evaluated due to time
-			if ignore {
-				continue
-			}
-
-			// save if better than previous move
-			if tempeval < eval {
-				eval = tempeval
-				best = move
-				temphistory[index_depth] = move.String()
-				history = temphistory
-				print_root_move_1(root, post, move, tempeval, beta, history)
-			} else {
-				print_root_move_2(root)
-			}
-
-			// store the eval of my best move, so far
-			if tempeval < beta {
-				beta = tempeval
-			}
-
-			// checkmate for black
-			if tempeval <= -1000000 {
-				break
-			}
-
-			// there exists a preferrable path elsewhere that will always be better for me
-			// my opponent has an option in this branch that i can't avoid, not looking anymore
-			if alpha >= beta {
-				break
-			}
+
+		// evaluate the position relatively (take current eval and take difference)
+		state_eval := evaluate_position(pos, preval, move)
+
+		// search one depth further, late quiet moves get reduced first and
+		// checks and forced replies are extended
+		reduction := late_move_reduction(pos, move, check, depth, searched)
+		extension := t.extension(pos, move, check, depth, single)
+
+		pos.make(move)
+		if pos.left_in_check() {
+			pos.unmake(move)
+			continue
 		}
-	}
+		tempeval, ignore := t.search_move(pos, depth+extension, alpha, beta, state_eval, searched == 0, reduction)
+		pos.unmake(move)
+		searched++
 
-	// collapse when time is up.
-	if best == nil {
-		return end_at_edge(game, depth, max, preval)
-	}
+		// the search was aborted while this move was evaluated
+		if ignore {
+			break
+		}
+
+		// save if better than previous move
+		if tempeval > eval {
+			eval = tempeval
+			best = move
+			t.update_pv(index_depth, move)
+			print_root_move_1(root, pos, move, tempeval, beta, t.pv[0][:t.pv_length[0]])
+		} else {
+			print_root_move_2(root)
+		}
+
+		// set alpha is better than alpha
+		if tempeval > alpha {
+			alpha = tempeval
+		}
+
+		// mate in one from here, nothing can be quicker
+		if tempeval >= -mated_in(index_depth+1) {
+			break
+		}
 
-	flip := 1
-	flag := AlphaFlag
-	value := alpha
-	m := make([]*chess.Move, 0, len(move_sorting))
-	for move := range move_sorting { // converts map to list for sorting and return
-		m = append(m, move)
+		// there exists a preferrable path elsewhere that is always better for me
+		// my opponent has an option in this branch that i can't avoid, not looking anymore
+		if alpha >= beta {
+			t.update_heuristics(pos, move, depth, index_depth)
+			break
+		}
 	}
-	if !max {
-		flip = -1
-		flag = BetaFlag
-		value = beta
+
+	// partial results of an aborted search are never used or stored
+	if t.check_time_up() {
+		return NO_MOVE, 0, true
 	}
 
-	// if a break ends, keep the uninvestigated moves but move them to end of list
-	if len(m) != len(moves) {
-		for _, move := range moves[len(m)-1:] {
-			move_sorting[move] = flip * -1 * 10000
+	// checkmate or stalemate
+	if searched == 0 {
+		if pos.in_check() {
+			return NO_MOVE, mated_in(index_depth), false
 		}
+		return NO_MOVE, t.draw_score(pos), false
 	}
 
-	// sort moves by how good they were
-	sort.Slice(m, func(i, j int) bool { return flip*move_sorting[m[i]] > flip*move_sorting[m[j]] })
-
 	// save this in the transposition table (ignores if time over)
-	write_hash(game.Position(), zobrist(game.Position().Board(), max), depth, flag, value, best, m)
+	t.write_hash(hash, depth, t.ply, bound_flag(eval, original_alpha, beta), eval, best)
 	print_minmax_root_end(root)
-	return best, eval, history, false
+	return best, eval, false
 }
 
-func quiescence_hashing(game *chess.Game, depth int, alpha int, beta int, max bool, preval int, moves []*chess.Move) (best *chess.Move, eval int, history [mem_size]string, ignore bool) {
-	if max {
-		eval = preval
-		for _, move := range moves {
+// searches the position after a move of the node at depth and returns its
+// score for the side that made the move. A reduced move is first searched
+// shallower with a null window and only gets the full search if it beats
+// alpha. With PVS (flag 5) only the first move gets the full window, the rest
+// are searched with a null window that just proves them worse, and re-searched
+// if that fails.
+func (t *Thread) search_move(pos *Position, depth int, alpha int, beta int, preval int, first bool, reduction int) (eval int, ignore bool) {
+	if reduction > 0 {
+		eval, ignore = t.search_child(pos, depth-1-reduction, -alpha-1, -alpha, preval)
+		if ignore || eval <= alpha {
+			return eval, ignore
+		}
+	}
+	if flag != 5 || first {
+		return t.search_child(pos, depth-1, -beta, -alpha, preval)
+	}
+	eval, ignore = t.search_child(pos, depth-1, -alpha-1, -alpha, preval)
+	if !ignore && eval > alpha && eval < beta {
+		eval, ignore = t.search_child(pos, depth-1, -beta, -alpha, preval)
+	}
+	return eval, ignore
+}
 
-			// create a new game and simulate the move
-			post := game.Clone()
-			post.Move(move)
+// searches a position one ply further from the root, the score is returned
+// for the side that moved into it
+func (t *Thread) search_child(pos *Position, depth int, alpha int, beta int, preval int) (eval int, ignore bool) {
+	t.ply++
+	_, eval, ignore = t.minimax_hashing(pos, depth, alpha, beta, preval)
+	t.ply--
+	return -eval, ignore
+}
 
-			state_eval := evaluate_position(game, post, preval, move)
+// captures and promotions, quiet checks at the horizon and every move out of
+// a check from there
+func (t *Thread) quiescence_hashing(pos *Position, hash uint64, depth int, alpha int, beta int, preval int, hashmove uint16) (best Move, eval int, ignore bool) {
+	max := pos.turn == chess.White
+	original_alpha := alpha
+	eval = relative_eval(preval, max)
+	stand := eval
+	evasions := quiescence_evasions(pos, depth)
+	if evasions {
+		eval = -math.MaxInt
+	}
+	// quiet checks only right at the horizon, anything deeper would explode
+	picker := t.new_picker(pos, t.ply, hashmove, evasions, DO_QUIESCENCE_CHECKS && depth == 0)
+	for move := picker.next(); move != NO_MOVE; move = picker.next() {
+		if !evasions && delta_prune(pos, move, stand, alpha) {
+			continue
+		}
 
-			_, tempeval, temphistory, ignore := minimax_hashing(post, depth-1, alpha, beta, !max, state_eval)
+		state_eval := evaluate_position(pos, preval, move)
 
-			if ignore {
-				continue
-			}
+		pos.make(move)
+		if pos.left_in_check() {
+			pos.unmake(move)
+			continue
+		}
+		tempeval, ignore := t.search_child(pos, depth-1, -beta, -alpha, state_eval)
+		pos.unmake(move)
 
-			// checkmate for black
-			if tempeval > eval {
-				eval = tempeval
-				best = move
-				temphistory[DEPTH-depth] = move.String() + "q"
-				history = temphistory
-			}
+		if ignore {
+			break
+		}
 
-			if tempeval > alpha {
-				alpha = tempeval
-			}
+		if tempeval > eval {
+			eval = tempeval
+			best = move
+			t.update_pv(t.ply, move)
+		}
 
-			// there exists a preferrable path elsewhere that will always be better for me
-			// my opponent has an option in this branch that i can't avoid, not looking anymore
-			if alpha >= beta {
-				break
-			}
+		if tempeval > alpha {
+			alpha = tempeval
 		}
-	} else {
-		eval = preval
-		for _, move := range moves {
-			post := game.Clone()
-			post.Move(move)
-			state_eval := evaluate_position(game, post, preval, move)
-			_, tempeval, temphistory, ignore := minimax_hashing(post, depth-1, alpha, beta, !max, state_eval)
-			if ignore {
-				continue
-			}
-
-			if tempeval < eval {
-				eval = tempeval
-				best = move
-				temphistory[DEPTH-depth] = move.String() + "q"
-				history = temphistory
-			}
-			if tempeval < beta {
-				beta = tempeval
-			}
-			if alpha >= beta {
-				break
-			}
+
+		// there exists a preferrable path elsewhere that will always be better for me
+		// my opponent has an option in this branch that i can't avoid, not looking anymore
+		if alpha >= beta {
+			break
 		}
 	}
-	if best == nil {
-		return end_at_edge(game, depth, max, preval)
+	if t.check_time_up() {
+		return NO_MOVE, 0, true
 	}
-	if !check_time_up() {
-		if max {
-			write_hash(game.Position(), zobrist(game.Position().Board(), max), depth, AlphaQFlag, alpha, best, moves)
-		} else {
-			write_hash(game.Position(), zobrist(game.Position().Board(), max), depth, BetaQFlag, beta, best, moves)
-		}
+	if best == NO_MOVE {
+		// standing pat, the moves were searched and delta pruned against this
+		// window so the score is only a bound like any other
+		eval = stand
 	}
-	return best, eval, history, false
+	t.write_hash(hash, depth, t.ply, bound_flag(eval, original_alpha, beta), eval, best)
+	return best, eval, false
 }
 
-func check_time_up() bool {
-	if !DO_ITERATIVE_DEEPENING || !DO_STRICT_TIMING {
+// true once the running search has to be aborted: stop signal, node limit or
+// (with strict timing) the clock. The first iteration of the main thread
+// always completes so there is a move to fall back on.
+func (t *Thread) check_time_up() bool {
+	if !DO_ITERATIVE_DEEPENING || (t.id == 0 && t.depth <= 1) {
 		return false
 	}
-	return delay.Sub(time.Now()) < 0
+	if atomic.LoadInt32(&stop_search) == 1 || atomic.LoadInt32(&helpers_stop) == 1 {
+		return true
+	}
+	if node_limit > 0 && t.id == 0 && total_explored() >= int64(node_limit) {
+		return true
+	}
+	return DO_STRICT_TIMING && past_deadline()
 }
 
-func end_at_edge(game *chess.Game, depth int, max bool, preval int) (best *chess.Move, eval int, history [mem_size]string, ignore bool) {
-	if check_time_up() {
-		history[DEPTH-depth] = "null"
-		return nil, 0, history, true
+// a true leaf, nothing was searched so the score holds for any window
+func (t *Thread) end_at_edge(pos *Position, hash uint64, depth int, preval int) (best Move, eval int, ignore bool) {
+	if t.check_time_up() {
+		return NO_MOVE, 0, true
 	}
-	history[DEPTH-depth] = "edge"
-	write_hash(game.Position(), zobrist(game.Position().Board(), max), depth, EdgeFlag, preval, nil, nil)
-	return nil, preval, history, false // history is blank
+	max := pos.turn == chess.White
+	eval = relative_eval(preval, max)
+	t.write_hash(hash, depth, t.ply, ExactFlag, eval, NO_MOVE)
+	return NO_MOVE, eval, false
 }
 
 // -------------------------
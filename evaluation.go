@@ -1,8 +1,6 @@
 package main
 
 import (
-	"sort"
-
 	"github.com/notnil/chess"
 )
 
@@ -139,121 +137,119 @@ func get_pos_val(piece chess.PieceType, x int8, y int8, max bool) int {
 	return 0
 }
 
-func evaluate_position(pre *chess.Game, post *chess.Game, preval int, move *chess.Move) (eval int) {
+// incremental update of the white relative score for a move of the position,
+// called before the move is made. Mates and draws are left to the search.
+func evaluate_position(pos *Position, preval int, move Move) (eval int) {
 	eval = preval
-	if move == nil { // first round evaluation
-		return position_eval
-	}
 
 	var flip int = 1
-	max := pre.Position().Turn() == chess.White
+	max := pos.turn == chess.White
 	if !max {
 		flip = -1
 	}
 
-	if post.Outcome() == chess.WhiteWon {
-		return 1000000
-	}
-	if post.Outcome() == chess.BlackWon {
-		return -1000000
-	}
-	if post.Outcome() == chess.Draw {
-		return 0
-	}
-
-	if move.HasTag(chess.Capture) {
-		// fmt.Println(PieceValue(game.Position().Board().Piece(move.S2()).Type()))
-		eval += flip * PieceValue(pre.Position().Board().Piece(move.S2()).Type())
+	if move.has(EN_PASSANT_FLAG) {
+		eval += flip * PieceValue(chess.Pawn)
+	} else if move.has(CAPTURE_FLAG) {
+		eval += flip * PieceValue(pos.squares[move.to()].Type())
 	}
 
-	move_type := pre.Position().Board().Piece(move.S1()).Type()
-	from := get_pos_val(move_type, int8(move.S1().File()), int8(move.S1().Rank()), max)
-	to := get_pos_val(move_type, int8(move.S2().File()), int8(move.S2().Rank()), max)
-	eval += flip * (to - from)	
+	move_type := pos.squares[move.from()].Type()
+	from := get_pos_val(move_type, int8(move.from().File()), int8(move.from().Rank()), max)
+	to := get_pos_val(move_type, int8(move.target().File()), int8(move.target().Rank()), max)
+	eval += flip * (to - from)
 
 	return eval
 }
 
-func get_quiescence_moves(game *chess.Game, moves []*chess.Move) []*chess.Move {
-	funcVar := func(move *chess.Move) bool {
-		if move.HasTag(chess.Capture) {
-			return true
+// captures and promotions for the old quiescence search. Filters and sorts
+// the list in place.
+func get_quiescence_moves(pos *Position, moves []Move) []Move {
+	var scores [MAX_POSITION_MOVES]int
+	result := moves[:0]
+	for _, move := range moves {
+		if is_quiet(move) {
+			continue
 		}
-		// if move.HasTag(chess.Check) {
-		// 	post := game.Clone()
-		// 	post.Move(move)
-		// 	if post.Outcome() == chess.WhiteWon || post.Outcome() == chess.BlackWon {
-		// 		return true
-		// 	}
-		// }
-		// perhaps include check moves?
-		if move.Promo() != chess.PieceType(0) {
-			return true
+		value := evaluate_quiescence_move(pos, move)
+		// moves that lose material can't raise the stand pat score
+		if DO_SEE_PRUNING && value < 0 {
+			continue
 		}
-		return false
+		scores[len(result)] = value
+		result = append(result, move)
 	}
+	sort_moves(result, scores[:len(result)])
+	return result
+}
 
-	evaluated := make(map[*chess.Move]int)
-	for _, move := range moves {
-		if funcVar(move) {
-			evaluated[move] = evaluate_quiescence_move(game, move)
+// insertion sort by descending score, lists are short and mostly small
+func sort_moves(moves []Move, scores []int) {
+	for i := 1; i < len(moves); i++ {
+		move, score := moves[i], scores[i]
+		j := i
+		for ; j > 0 && scores[j-1] < score; j-- {
+			moves[j], scores[j] = moves[j-1], scores[j-1]
 		}
+		moves[j], scores[j] = move, score
 	}
-
-	result := make([]*chess.Move, 0, len(evaluated))
-	for key := range evaluated {
-		result = append(result, key)
-	}
-	sort.Slice(result, func(i, j int) bool { return evaluated[result[i]] > evaluated[result[j]] })
-
-	return result
 }
 
-func move_order(game *chess.Game, moves []*chess.Move) []*chess.Move {
-	evaluated := make(map[*chess.Move]int)
-	for _, move := range moves {
-		evaluated[move] = evaluate_move(game, move)
-	}
+const GOOD_CAPTURE_BONUS int = 1000 // winning and equal captures go before the quiet moves in move_order
 
-	keys := make([]*chess.Move, 0, len(evaluated))
-	for key := range evaluated {
-		keys = append(keys, key)
+// sorts the list in place by SEE and piece square gain, for the old searches
+// (the main search orders with the MovePicker)
+func move_order(pos *Position, moves []Move) []Move {
+	var scores [MAX_POSITION_MOVES]int
+	for i, move := range moves {
+		scores[i] = evaluate_move(pos, move)
 	}
-
-	sort.Slice(keys, func(i, j int) bool { return evaluated[keys[i]] > evaluated[keys[j]] })
-
-	return keys
+	sort_moves(moves, scores[:len(moves)])
+	return moves
 }
 
-func evaluate_move(game *chess.Game, move *chess.Move) (eval int) {
-	if move.Promo() != chess.PieceType(0) {
+func evaluate_move(pos *Position, move Move) (eval int) {
+	if move.promo() != chess.NoPieceType {
 		return 2000
 	}
 
 	eval = 0
-	max := game.Position().Turn() == chess.White
+	max := pos.turn == chess.White
 
-	move_type := game.Position().Board().Piece(move.S1()).Type()
+	move_type := pos.squares[move.from()].Type()
 
-	if move.HasTag(chess.Capture) {
-		eval += PieceValue(game.Position().Board().Piece(move.S2()).Type()) - PieceValue(move_type)
-	} else if move.HasTag(chess.Check) {
+	if move.has(CAPTURE_FLAG) {
+		exchange := see(pos, move)
+		eval += exchange
+		if exchange >= 0 {
+			eval += GOOD_CAPTURE_BONUS
+		}
+	} else if pos.gives_check(move) {
 		eval += 10
 	}
 
-	from := get_pos_val(move_type, int8(move.S1().File()), int8(move.S1().Rank()), max)
-	to := get_pos_val(move_type, int8(move.S2().File()), int8(move.S2().Rank()), max)
+	from := get_pos_val(move_type, int8(move.from().File()), int8(move.from().Rank()), max)
+	to := get_pos_val(move_type, int8(move.target().File()), int8(move.target().Rank()), max)
 	eval += to - from
 
 	return
 }
 
-func evaluate_quiescence_move(game *chess.Game, move *chess.Move) int {
-	move_type := game.Position().Board().Piece(move.S1()).Type()
-	return PieceValue(game.Position().Board().Piece(move.S2()).Type()) - PieceValue(move_type)
+func evaluate_quiescence_move(pos *Position, move Move) int {
+	return see(pos, move)
 }
 
-// this should be static, not relative
-func update_evaluation(game *chess.Game, pre *chess.Game, move *chess.Move) {
-	position_eval = evaluate_position(pre, game, position_eval, move)
+// material and piece square tables of the whole board, white relative. The
+// search starts from it at the root and updates it move by move.
+func (pos *Position) static_eval() (eval int) {
+	for sq := chess.A1; sq <= chess.H8; sq++ {
+		piece := pos.squares[sq]
+		if piece == chess.NoPiece {
+			continue
+		}
+		white := piece.Color() == chess.White
+		value := PieceValue(piece.Type()) + get_pos_val(piece.Type(), int8(sq.File()), int8(sq.Rank()), white)
+		eval += relative_eval(value, white)
+	}
+	return
 }
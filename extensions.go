@@ -0,0 +1,26 @@
+package main
+
+// Check extension: a move that gives check without losing material is
+// searched one ply deeper, so mating attacks don't get cut off at the
+// horizon. The only legal move out of check gets the same treatment (one
+// reply extension). Extended paths stop growing once they are twice the iteration
+// depth, and can never reach the end of the per ply arrays.
+func (t *Thread) extension(pos *Position, move Move, check bool, depth int, single bool) int {
+	if t.ply >= 2*t.depth || t.ply+depth+1 >= MAX_DEPTH {
+		return 0
+	}
+	if DO_CHECK_EXTENSION && check && see(pos, move) >= 0 {
+		return 1
+	}
+	if DO_ONE_REPLY_EXTENSION && single {
+		return 1
+	}
+	return 0
+}
+
+// Only the reply to a quiet check from the first quiescence ply has to get
+// out of check with any move, deeper checks still stand pat so chains of
+// checking captures can't blow up the tree.
+func quiescence_evasions(pos *Position, depth int) bool {
+	return DO_QUIESCENCE_EVASIONS && depth == -1 && pos.in_check()
+}
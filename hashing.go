@@ -1,63 +1,88 @@
 package main
 
 import (
-	"fmt"
-	"math"
 	"math/rand"
+	"sync"
+	"sync/atomic"
 
 	"github.com/notnil/chess"
 )
 
-type hashed struct {
-	hash     uint64
-	depth    int
-	flag     HashFlag
-	score    int
-	best     *chess.Move
-	moves    []*chess.Move
-	position *chess.Position
+/*
+
+Transposition table.
+
+A fixed array of buckets sized from the Hash option. Every bucket has a depth
+preferred slot, only replaced by deeper results or entries from an older
+search, and an always replace slot that takes everything else. Entries are
+compact: the best move packed into 16 bits, score, depth, flag and the search
+they came from fit one word.
+
+The threads share the table without a lock. A slot is two words, the data
+and the key xored with it, each read and written atomically. A read racing a
+write sees a pair that doesn't give back its key and takes it as a miss, so
+torn entries are never used. Only resizing and clearing take the lock.
+
+*/
+
+const DEFAULT_HASH_MB int = 16
+const MAX_HASH_MB int = 4096
+
+type hash_entry struct {
+	score int32
+	move  uint16 // from | to << 6 | promotion << 12, 0 for none
+	depth int8   // searches never get near 128 plies, quiescence included
+	flag  HashFlag
+	age   uint8
+}
+
+type hash_slot struct {
+	check uint64 // key ^ data
+	data  uint64 // move | depth << 16 | flag << 24 | age << 26 | score << 32
 }
 
-// PieceType is the type of a piece.
+type hash_bucket [2]hash_slot // depth preferred, always replace
+
+// what the stored score proves about the position
 type HashFlag int8
 
 const (
-	// Null flag
+	// Empty slot
 	NoFlag HashFlag = iota
-	// Edge of the search
-	EdgeFlag
-	// Non-edge from a maximizer
-	AlphaFlag
-	// Non-edge from a minimizer
-	BetaFlag
-	// Non-edge from a maximizer in quiescence
-	AlphaQFlag
-	// Non-edge from a minimizer in quiescence
-	BetaQFlag
+	// The score is the value of the position
+	ExactFlag
+	// The search failed high, the value is at least the score
+	LowerFlag
+	// The search failed low, the value is at most the score
+	UpperFlag
 )
 
-// PieceType is the type of a piece.
+// what a probe found for the node
 type HashResult int8
 
 const (
-	// Null result
+	// Nothing stored
 	NoResult HashResult = iota
-	// Result from deeper edge search
+	// Deep enough and the bound proves the score, no search needed
 	DeeperResult
-	// Result from deeper quiescence search
-	QuiescenceDeeperResult
-	// Moves saved from previous Minimax search
-	MinimaxSavedMoves
-	// Moves saved from previous quiescence search
-	QuiescenceSavedMoves
+	// Only the best move is of use, for move ordering
+	HashMove
 )
 
-var hash_map = make(map[uint64]hashed)
+var HASH_MB int = DEFAULT_HASH_MB // set with the Hash option
+var hash_table = make([]hash_bucket, hash_buckets(HASH_MB))
+var hash_age uint8 = 0   // bumped for every search, older entries get replaced first, 6 bits
+var hash_lock sync.Mutex // only for resizing and clearing, probes and stores go without
 var whiteToMoveZobrist uint64
 var pieceSquareZobrist [12][64]uint64
-var castleRightsZobrist [4]uint64
+var castleRightsZobrist [4]uint64 // white king side, white queen side, black king side, black queen side
+var enPassantZobrist [8]uint64
 
 func generateZobristConstants() {
+	if POLYGLOT_KEYS {
+		load_polyglot_keys()
+		return
+	}
 	whiteToMoveZobrist = rand.Uint64()
 	for i := 0; i < 12; i++ {
 		for j := 0; j < 64; j++ {
@@ -67,76 +92,206 @@ func generateZobristConstants() {
 	for i := 0; i < 4; i++ {
 		castleRightsZobrist[i] = rand.Uint64()
 	}
+	for i := 0; i < 8; i++ {
+		enPassantZobrist[i] = rand.Uint64()
+	}
 }
 
-func zobrist(board *chess.Board, max bool) uint64 {
+// key of a position from scratch: pieces, side to move, castling rights and
+// the en passant file when a pawn can actually take en passant
+func (pos *Position) compute_key() uint64 {
 	var bits uint64 = 0
-	pos := board.SquareMap()
-	if max {
+	if pos.turn == chess.White {
 		bits = bits ^ whiteToMoveZobrist
 	}
-	for square, piece := range pos {
-		value := pieceSquareZobrist[int8(piece)-1][int(square)]
-		bits = bits ^ value
+	for sq, piece := range pos.squares {
+		if piece != chess.NoPiece {
+			bits = bits ^ piece_key(piece, chess.Square(sq))
+		}
+	}
+	bits = bits ^ castle_key(pos.castle)
+	bits = bits ^ pos.en_passant_key()
+	return bits
+}
+
+// key of a library position, for the edges: book lookups and tests
+func zobrist(pos *chess.Position) uint64 {
+	return position_from_chess(pos).key
+}
+
+func piece_key(piece chess.Piece, square chess.Square) uint64 {
+	return pieceSquareZobrist[int8(piece)-1][int(square)]
+}
+
+// rights are CASTLE_ bits in the order of castleRightsZobrist
+func castle_key(rights uint8) uint64 {
+	var bits uint64 = 0
+	for i := range castleRightsZobrist {
+		if rights&(1<<i) != 0 {
+			bits = bits ^ castleRightsZobrist[i]
+		}
 	}
 	return bits
 }
 
-func write_hash(position *chess.Position, hash uint64, depth int, flag HashFlag, score int, best *chess.Move, moves []*chess.Move) {
-	if check_time_up() {
+// the en passant file only counts if a pawn of the side to move can take
+func (pos *Position) en_passant_key() uint64 {
+	if pos.ep == chess.NoSquare {
+		return 0
+	}
+	// pawns that could take on the square are where an enemy pawn would attack it from
+	if pawn_attacks[side_index(pos.turn.Other())][pos.ep]&pos.bitboard(chess.Pawn, pos.turn) == 0 {
+		return 0
+	}
+	return enPassantZobrist[pos.ep.File()]
+}
+
+// pieces are numbered king to pawn, white first
+func color_piece(p chess.PieceType, color chess.Color) chess.Piece {
+	if color == chess.Black {
+		return chess.Piece(int8(p) + 6)
+	}
+	return chess.Piece(p)
+}
+
+func hash_buckets(mb int) int {
+	return mb * 1024 * 1024 / 32
+}
+
+// reallocates the table for a new memory budget, this clears it
+func resize_hash(mb int) {
+	hash_lock.Lock()
+	defer hash_lock.Unlock()
+	HASH_MB = Max(1, Min(MAX_HASH_MB, mb))
+	hash_table = make([]hash_bucket, hash_buckets(HASH_MB))
+}
+
+func clear_hash() {
+	hash_lock.Lock()
+	defer hash_lock.Unlock()
+	for i := range hash_table {
+		hash_table[i] = hash_bucket{}
+	}
+	hash_age = 0
+}
+
+// entries written from now on belong to a new search, called before the threads start
+func new_hash_search() {
+	hash_age = (hash_age + 1) & 63
+}
+
+func (e hash_entry) pack() uint64 {
+	return uint64(e.move) | uint64(uint8(e.depth))<<16 | uint64(e.flag)<<24 | uint64(e.age)<<26 | uint64(uint32(e.score))<<32
+}
+
+func unpack(data uint64) hash_entry {
+	return hash_entry{
+		score: int32(data >> 32),
+		move:  uint16(data),
+		depth: int8(data >> 16),
+		flag:  HashFlag(data >> 24 & 3),
+		age:   uint8(data >> 26 & 63),
+	}
+}
+
+// the entry in the slot and whether it belongs to the key
+func (slot *hash_slot) load(hash uint64) (hash_entry, bool) {
+	data := atomic.LoadUint64(&slot.data)
+	check := atomic.LoadUint64(&slot.check)
+	entry := unpack(data)
+	return entry, entry.flag != NoFlag && check^data == hash
+}
+
+func (slot *hash_slot) store(hash uint64, entry hash_entry) {
+	data := entry.pack()
+	atomic.StoreUint64(&slot.data, data)
+	atomic.StoreUint64(&slot.check, hash^data)
+}
+
+// from, to and promotion, the flags follow from the position
+func encode_move(move Move) uint16 {
+	return uint16(move)
+}
+
+// the move of the list a packed table move stands for, NO_MOVE if there's none
+func decode_move(moves []Move, code uint16) Move {
+	if code == 0 {
+		return NO_MOVE
+	}
+	for _, move := range moves {
+		if encode_move(move) == code {
+			return move
+		}
+	}
+	return NO_MOVE
+}
+
+// callers make sure nothing from an aborted search gets written
+// mate scores are stored relative to the node, ply is its distance from the root
+func (t *Thread) write_hash(hash uint64, depth int, ply int, flag HashFlag, score int, best Move) {
+	t.hash_write_count++
+	entry := hash_entry{
+		score: int32(score_to_hash(score, ply)),
+		move:  encode_move(best),
+		depth: int8(depth),
+		flag:  flag,
+		age:   hash_age,
+	}
+	bucket := &hash_table[hash%uint64(len(hash_table))]
+	preferred, same := bucket[0].load(hash)
+	if preferred.flag == NoFlag || same || preferred.age != hash_age || depth >= int(preferred.depth) {
+		// keep the best move of a shallower search of the same position
+		if entry.move == 0 && same {
+			entry.move = preferred.move
+		}
+		bucket[0].store(hash, entry)
 		return
 	}
-	hash_write_count++
-	p := hashed{
-		hash:     hash,
-		depth:    depth,
-		flag:     flag,
-		score:    score,
-		best:     best,
-		moves:    moves,
-		position: position,
-	}
-	hash_map[hash] = p
-}
-
-func read_hash(hash uint64, depth int, alpha int, beta int) (flag HashResult, score int, best *chess.Move, moves []*chess.Move, depthfound int) {
-	p := hash_map[hash]
-	if p.flag != 0 {
-		if p.hash == hash {
-			hash_count++
-			if p.depth >= depth {
-				if p.flag == EdgeFlag {
-					hash_count_list[0]++
-					return DeeperResult, p.score, nil, nil, p.depth
-				}
-				if p.flag == AlphaFlag && p.score > alpha {
-					hash_count_list[1]++
-					return DeeperResult, p.score, p.best, p.moves, p.depth
-				}
-				if p.flag == BetaFlag && p.score < beta {
-					hash_count_list[2]++
-					return DeeperResult, p.score, p.best, p.moves, p.depth
-				}
-				if p.flag == AlphaQFlag {
-					hash_count_list[0]++
-					return QuiescenceDeeperResult, p.score, p.best, p.moves, p.depth
-				}
-				if p.flag == BetaQFlag {
-					hash_count_list[0]++
-					return QuiescenceDeeperResult, p.score, p.best, p.moves, p.depth
-				}
-			}
-			// this has no data but make sure it doesn't get sent
-			if p.flag == EdgeFlag && true {
-				return NoResult, int(math.NaN()), nil, nil, 0
-			} else if p.flag == BetaQFlag || p.flag == AlphaQFlag {
-				return QuiescenceSavedMoves, 0, p.best, p.moves, p.depth
-			} else if p.flag == BetaFlag || p.flag == AlphaFlag {
-				return MinimaxSavedMoves, 0, p.best, p.moves, p.depth
-			}
-		} else {
-			fmt.Println("HASH CONFLICT", hash, p.hash, p)
+	bucket[1].store(hash, entry)
+}
+
+func probe_hash(hash uint64) (hash_entry, bool) {
+	bucket := &hash_table[hash%uint64(len(hash_table))]
+	for i := range bucket {
+		if entry, found := bucket[i].load(hash); found {
+			return entry, true
+		}
+	}
+	return hash_entry{}, false
+}
+
+// a stored score only ends the search if it was searched at least as deep and
+// its bound settles the node for the window: exact, a lower bound at or above
+// beta, or an upper bound at or below alpha
+func (t *Thread) read_hash(hash uint64, depth int, ply int, alpha int, beta int) (flag HashResult, score int, best uint16, depthfound int) {
+	p, found := probe_hash(hash)
+	if !found {
+		return NoResult, 0, 0, 0
+	}
+	t.hash_count++
+	score = score_from_hash(int(p.score), ply)
+	if int(p.depth) >= depth {
+		switch {
+		case p.flag == ExactFlag:
+			t.hash_count_list[0]++
+			return DeeperResult, score, p.move, int(p.depth)
+		case p.flag == LowerFlag && score >= beta:
+			t.hash_count_list[1]++
+			return DeeperResult, score, p.move, int(p.depth)
+		case p.flag == UpperFlag && score <= alpha:
+			t.hash_count_list[2]++
+			return DeeperResult, score, p.move, int(p.depth)
 		}
 	}
-	return NoResult, 0, nil, nil, 0
+	return HashMove, 0, p.move, int(p.depth)
+}
+
+// bound of a fail soft result searched with the window alpha, beta
+func bound_flag(eval int, alpha int, beta int) HashFlag {
+	if eval >= beta {
+		return LowerFlag
+	} else if eval <= alpha {
+		return UpperFlag
+	}
+	return ExactFlag
 }
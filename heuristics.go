@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/notnil/chess"
+)
+
+/*
+
+Quiet move ordering heuristics, all filled in on beta cutoffs:
+
+Killer moves: the last two quiet moves that cut off at the same ply.
+History: butterfly table (side, from, to) of how often a quiet move cut off, weighted by depth.
+Countermoves: the quiet move that refuted the opponent's previous move.
+
+*/
+
+const HISTORY_LIMIT int = 1 << 20 // tables are halved once an entry reaches this
+
+// killers and countermoves are position specific, history is only aged.
+// Every thread keeps its own tables.
+func (t *Thread) clear_heuristics() {
+	for i := range t.killer_moves {
+		t.killer_moves[i] = [2]Move{}
+	}
+	t.countermove_table = [2][64][64]Move{}
+	t.age_history()
+}
+
+func (t *Thread) age_history() {
+	for side := range t.history_table {
+		for from := range t.history_table[side] {
+			for to := range t.history_table[side][from] {
+				t.history_table[side][from][to] /= 2
+			}
+		}
+	}
+}
+
+// from, to and promotion match, moves kept from other positions may carry other flags
+func same_move(a Move, b Move) bool {
+	return encode_move(a) == encode_move(b)
+}
+
+func is_quiet(move Move) bool {
+	return !move.has(CAPTURE_FLAG) && move.promo() == chess.NoPieceType
+}
+
+func side_index(color chess.Color) int {
+	if color == chess.White {
+		return 0
+	}
+	return 1
+}
+
+// called when a move caused a beta cutoff at ply
+func (t *Thread) update_heuristics(pos *Position, move Move, depth int, ply int) {
+	if !is_quiet(move) || ply >= mem_size {
+		return
+	}
+	if !same_move(move, t.killer_moves[ply][0]) {
+		t.killer_moves[ply][1] = t.killer_moves[ply][0]
+		t.killer_moves[ply][0] = move
+	}
+
+	side := side_index(pos.turn)
+	t.history_table[side][move.from()][move.to()] += depth * depth
+	if t.history_table[side][move.from()][move.to()] >= HISTORY_LIMIT {
+		t.age_history()
+	}
+
+	if previous := pos.last_move(); previous != NO_MOVE {
+		t.countermove_table[side][previous.from()][previous.to()] = move
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"math"
+	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/notnil/chess"
@@ -10,6 +14,20 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "uci":
+			uci_loop()
+			return
+		case "xboard":
+			xboard_loop()
+			return
+		case "perft", "divide":
+			perft_command(os.Args[1:])
+			return
+		}
+	}
+
 	run_tests()
 	game := setup()
 
@@ -43,35 +61,46 @@ func main() {
 			panic("NO MOVE")
 		}
 
-		pre := game.Clone()
 		game.Move(move)
 
 		print_turn_complete(game, move, start)
-		update_evaluation(game, pre, move)
 		move_count++
 	}
 	print_game_over(game)
 }
 
-func iterative_deepening_mtdf(game *chess.Game, time_control int, max bool) (output *chess.Move) {
+func (t *Thread) iterative_deepening_mtdf(max bool, tm *TimeManager) (output Move) {
 
-	DEPTH = 1 // starting depth
-	delay = time.Now().Add(time.Second * time.Duration(time_control))
+	t.depth = 1 // starting depth
+	only_move := len(t.pos.legal_moves(t.moves[0][:0])) == 1
 	var eval int = 0
-	var history [mem_size]string
+	var line []Move
 
-	for time.Now().Sub(delay) < 0 {
+	for !past_deadline() {
+		DEPTH = t.depth
 		fmt.Println("\n\nnew depth", DEPTH)
 		
-		print_iter_1(delay)
+		print_iter_1(search_deadline())
 
-		output, eval, history = mtdf_algo(game, DEPTH, max, eval)
+		iteration_start := time.Now()
+		best, value, pv := t.mtdf_algo(t.pos, t.depth, max, eval)
+		// an aborted iteration is incomplete, keep the last completed one
+		if t.check_time_up() {
+			break
+		}
+		output, eval, line = best, value, legal_pv(t.pos, pv)
+		t.prev_pv = line
+		t.completed = t.depth
+		t.publish_counts()
 		
-		print_iter_11(output, eval, history)
+		print_iter_11(output, eval, line)
 		print_iter_2()
 		
-		DEPTH++
-		if eval >= 10000 || eval <= -10000 || DEPTH > MAX_ITERATIVE_DEPTH {
+		t.depth++
+		if eval >= 10000 || eval <= -10000 || t.search_limit_reached() {
+			break
+		}
+		if !tm.keep_searching(output, relative_eval(eval, max), time.Since(iteration_start), only_move) {
 			break
 		}
 	}
@@ -79,24 +108,38 @@ func iterative_deepening_mtdf(game *chess.Game, time_control int, max bool) (out
 	return
 }
 
-func iterative_deepening(game *chess.Game, time_control int, max bool) (output *chess.Move) {
+func (t *Thread) iterative_deepening(max bool, tm *TimeManager) (output Move) {
 
-	DEPTH = 1 // starting depth
-	delay = time.Now().Add(time.Second * time.Duration(time_control))
+	t.depth = 1 // starting depth
+	only_move := len(t.pos.legal_moves(t.moves[0][:0])) == 1
 	var eval int
-	var history [mem_size]string
+	var line []Move
 
-	for time.Now().Sub(delay) < 0 {
+	for !past_deadline() {
+		DEPTH = t.depth
 		
-		print_iter_1(delay)
+		print_iter_1(search_deadline())
 
-		output, eval, history = minimax_factory(game, 0, max)
+		iteration_start := time.Now()
+		best, value, pv := t.aspiration_search(t.pos, max, eval)
+		// an aborted iteration is incomplete, keep the last completed one
+		if t.check_time_up() {
+			break
+		}
+		output, eval, line = best, value, legal_pv(t.pos, pv)
+		t.prev_pv = line
+		t.best, t.eval, t.completed = output, eval, t.depth
+		t.publish_counts()
 		
-		print_iter_11(output, eval, history)
+		print_iter_11(output, eval, line)
+		print_iter_info(eval, line, max)
 		print_iter_2()
 		
-		DEPTH++
-		if eval >= 10000 || eval <= -10000 || DEPTH > MAX_ITERATIVE_DEPTH {
+		t.depth++
+		if eval >= 10000 || eval <= -10000 || t.search_limit_reached() {
+			break
+		}
+		if !tm.keep_searching(output, relative_eval(eval, max), time.Since(iteration_start), only_move) {
 			break
 		}
 	}
@@ -111,15 +154,246 @@ func run_tests() {
 	init_explored_depth()
 	init_hash_count()
 	generateZobristConstants()
-	fen, _ := chess.FEN("3qr2k/pbpp2pp/1p5N/3Q2b1/2P1P3/P7/1PP2PPP/R4RK1 w - - 0 1")
+	// tactics the search has to find at a fixed depth, every table cutoff
+	// along the way has to be backed by a bound that proves it
+	test_tactic("3qr2k/pbpp2pp/1p5N/3Q2b1/2P1P3/P7/1PP2PPP/R4RK1 w - - 0 1", 3, "d5g8") // smothered mate
+	test_tactic("6k1/5ppp/8/8/8/8/5PPP/3R2K1 w - - 0 1", 2, "d1d8")                     // back rank mate
+	test_tactic("3r2k1/5ppp/8/8/8/8/5PPP/6K1 b - - 0 1", 2, "d8d1")                     // the same for black
+	test_tactic("r1b2k1r/ppp1bppp/8/1B1Q4/5q2/2P5/PPP2PPP/R3R1K1 w - - 1 1", 3, "d5d8") // queen sacrifice, mate in two
+	test_tactic("2q1k3/8/8/1N6/8/8/8/4K3 w - - 0 1", 3, "b5d6")                          // knight fork
+	test_tactic("4k3/8/8/3q4/8/8/8/3RK3 w - - 0 1", 2, "d1d5")                           // hanging queen
+	test_tactic("8/P7/8/8/8/8/k7/4K3 w - - 0 1", 2, "a7a8q")                             // promotion
+
+	// the same search again starts from a warm table, the lines cut off by it
+	// still have to reach the horizon
+	test_pv("8/8/8/4k3/8/8/4P3/4K3 w - - 0 1", 8)
+	test_pv("r1bqkbnr/pppp1ppp/2n5/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R w KQkq - 2 3", 6)
+
+	// static exchange evaluation: rook wins a pawn, knight runs into x-rays, en passant
+	test_see("1k1r4/1pp4p/p7/4p3/8/P5P1/1PP4P/2K1R3 w - - 0 1", "e1e5", 100)
+	test_see("1k1r3q/1ppn3p/p4b2/4p3/8/P2N2P1/1PP1R1BP/2K1Q3 w - - 0 1", "d3e5", -220)
+	test_see("4k3/8/2p5/3p4/4Q3/8/8/4K3 w - - 0 1", "e4d5", -800)
+	test_see("4k3/8/8/3pP3/8/8/8/4K3 w - d6 0 1", "e5d6", 100)
+
+	// move generation against the known counts, the bigger ones run with perft suite
+	if !run_perft_suite(100000, false) {
+		panic("PERFT TEST FAILED")
+	}
+
+	// mate scores count moves from the root
+	if uci_score(-mated_in(3)) != "mate 2" || uci_score(mated_in(4)) != "mate -2" || score_from_hash(score_to_hash(mated_in(5), 2), 3) != mated_in(6) {
+		panic("MATE SCORE TEST FAILED")
+	}
+
+	// the start position after both knights went out and back twice is a threefold repetition
+	game := new_uci_game(nil)
+	for _, m := range []string{"g1f3", "g8f6", "f3g1", "f6g8", "g1f3", "g8f6", "f3g1", "f6g8"} {
+		game.MoveStr(m)
+	}
+	t := &Thread{}
+	t.setup_path(position_from_game(game))
+	if !t.path_draw(zobrist(game.Position()), t.root_fifty) || t.root_fifty != 8 {
+		panic("REPETITION TEST FAILED")
+	}
+	// a queen up, Qe4+ lets black repeat the position a third time
+	test_repetition("7k/6p1/7p/8/4Q3/8/PPP5/1K6 w - - 0 1", []string{"e4e8", "h8h7", "e8e4", "h7h8", "e4e8", "h8h7"}, 6, "e8e4")
+
+	// incremental keys through a double push next to a pawn, en passant,
+	// castling on both sides and a capturing promotion
+	test_zobrist("r3k2r/1ppp1ppp/8/4P3/8/8/pPPP1PPP/RN2K2R b KQkq - 0 1", []string{"d7d5", "e5d6", "e8c8", "e1g1", "a2b1q", "a1b1", "b7b5"})
+
+	// reference keys of the Polyglot book format
+	test_polyglot([]string{}, 0x463b96181691fc9c)
+	test_polyglot([]string{"e2e4"}, 0x823c9b50fd114196)
+	test_polyglot([]string{"e2e4", "d7d5"}, 0x0756b94461c50fb0)
+	test_polyglot([]string{"e2e4", "d7d5", "e4e5"}, 0x662fafb965db29d4)
+	test_polyglot([]string{"e2e4", "d7d5", "e4e5", "f7f5"}, 0x22a48b5a8e47ff78)
+	test_polyglot([]string{"e2e4", "d7d5", "e4e5", "f7f5", "e1e2"}, 0x652a607ca3f242c1)
+	test_polyglot([]string{"e2e4", "d7d5", "e4e5", "f7f5", "e1e2", "e8f7"}, 0x00fdd303c946bdd9)
+	test_polyglot([]string{"a2a4", "b7b5", "h2h4", "b5b4", "c2c4"}, 0x3c8123ea7b067637)
+	test_polyglot([]string{"a2a4", "b7b5", "h2h4", "b5b4", "c2c4", "b4c3", "a1a3"}, 0x5c3f9b829b279560)
+	test_polyglot_option()
+
+	test_chess960()
+
+	VERBOSE_FLAG = stored
+	fmt.Print("Tests passed...\n\n")
+}
+
+func test_tactic(position string, depth int, expected string) {
+	fen, _ := chess.FEN(position)
 	game := chess.NewGame(fen)
+	clear_hash()
+	apply_limits(SearchLimits{depth: depth}, true)
 	move := engine(game, true)
-	fmt.Println(move)
-	if move.String() != "d5g8" {
-		panic("TEST FAILED")
+	apply_limits(SearchLimits{}, true)
+	if move == nil || move.String() != expected {
+		panic("TACTIC TEST FAILED " + expected)
+	}
+}
+
+// the side to move is clearly winning and one move allows a repetition, the
+// draw has to score below playing on and the score must stay absolute
+func test_repetition(position string, moves []string, depth int, repeat string) {
+	fen, _ := chess.FEN(position)
+	game := new_uci_game(fen)
+	for _, m := range moves {
+		if game.MoveStr(m) != nil {
+			panic("REPETITION TEST FAILED " + m)
+		}
+	}
+	max := game.Position().Turn() == chess.White
+	clear_hash()
+	apply_limits(SearchLimits{depth: depth}, max)
+	move := engine_move(position_from_game(game), nil, max)
+	apply_limits(SearchLimits{}, max)
+	if move == NO_MOVE || move.String() == repeat || relative_eval(main_thread().eval, max) < 500 {
+		panic("REPETITION TEST FAILED " + repeat)
+	}
+}
+
+// a search at depth twice, the second line has at least depth moves
+func test_pv(position string, depth int) {
+	root, _ := new_position(position)
+	max := root.turn == chess.White
+	clear_hash()
+	apply_limits(SearchLimits{depth: depth}, max)
+	engine_move(root, nil, max)
+	engine_move(root, nil, max)
+	apply_limits(SearchLimits{}, max)
+	if len(main_thread().prev_pv) < depth {
+		panic("PV TEST FAILED " + strings.Join(pv_strings(main_thread().prev_pv), " "))
+	}
+}
+
+// incremental keys of make against keys from scratch of the library's positions
+func test_zobrist(position string, moves []string) {
+	fen, _ := chess.FEN(position)
+	game := new_uci_game(fen)
+	pos, _ := new_position(position)
+	for _, m := range moves {
+		move := pos.parse_move(m)
+		if move == NO_MOVE || game.MoveStr(m) != nil {
+			panic("ZOBRIST TEST FAILED " + m)
+		}
+		pos.make(move)
+		// the library resets the fifty move counter on castling, so the clocks aren't compared
+		if pos.key != zobrist(game.Position()) || board_fields(pos.fen()) != board_fields(game.Position().String()) {
+			panic("ZOBRIST TEST FAILED " + m)
+		}
+	}
+	for i := len(moves) - 1; i >= 0; i-- {
+		pos.unmake(pos.last_move())
+	}
+	if pos.fen() != position || pos.key != pos.compute_key() {
+		panic("ZOBRIST TEST FAILED unmake")
+	}
+}
+
+// plays the moves from the start position with Polyglot keys, the key is
+// checked from scratch and incrementally after the last one
+func test_polyglot(moves []string, expected uint64) {
+	stored := POLYGLOT_KEYS
+	POLYGLOT_KEYS = true
+	generateZobristConstants()
+	defer func() {
+		POLYGLOT_KEYS = stored
+		generateZobristConstants()
+	}()
+
+	pos, _ := new_position(start_pos)
+	for _, m := range moves {
+		move := pos.parse_move(m)
+		if move == NO_MOVE {
+			panic("POLYGLOT TEST FAILED " + m)
+		}
+		pos.make(move)
+	}
+	if pos.key != expected || pos.compute_key() != expected {
+		panic(fmt.Sprintf("POLYGLOT TEST FAILED %016x", pos.key))
+	}
+}
+
+// switching the option keys the loaded position with the new constants
+func test_polyglot_option() {
+	stored := POLYGLOT_KEYS
+	defer func() {
+		POLYGLOT_KEYS = stored
+		generateZobristConstants()
+		clear_hash()
+	}()
+	state := &uci_state{}
+	uci_position(state, []string{"startpos", "moves", "e2e4"})
+	uci_setoption(state, []string{"name", "PolyglotKeys", "value", "true"})
+	if state.root.key != 0x823c9b50fd114196 {
+		panic(fmt.Sprintf("POLYGLOT OPTION TEST FAILED %016x", state.root.key))
+	}
+}
+
+// start positions and castling with the king taking its rook: short castling
+// onto the rook's square, long castling past it, and back again
+func test_chess960() {
+	seen := map[string]bool{}
+	for i := 0; i < 960; i++ {
+		fen := chess960_fen(i)
+		pos, err := new_position(fen)
+		if err != nil || pos.fen() != fen || seen[fen] {
+			panic("CHESS960 TEST FAILED " + fen)
+		}
+		seen[fen] = true
+	}
+	if chess960_fen(518) != start_pos {
+		panic("CHESS960 TEST FAILED 518")
+	}
+
+	stored := CHESS960
+	CHESS960 = true
+	defer func() { CHESS960 = stored }()
+	position := "1r2k1r1/pppppppp/8/8/8/8/PPPPPPPP/1R2K1R1 w GBgb - 0 1"
+	pos, _ := new_position(position)
+	for _, m := range []string{"e1g1", "e8b8"} {
+		move := pos.parse_move(m)
+		if move == NO_MOVE || !move.has(CASTLE_FLAG) {
+			panic("CHESS960 TEST FAILED " + m)
+		}
+		pos.make(move)
+		if pos.key != pos.compute_key() {
+			panic("CHESS960 TEST FAILED key " + m)
+		}
+	}
+	CHESS960 = false
+	if pos.fen() != "2kr2r1/pppppppp/8/8/8/8/PPPPPPPP/1R3RK1 w - - 2 2" || pos.last_move().String() != "e8c8" {
+		panic("CHESS960 TEST FAILED " + pos.fen())
+	}
+	pos.unmake(pos.last_move())
+	pos.unmake(pos.last_move())
+	if pos.fen() != "1r2k1r1/pppppppp/8/8/8/8/PPPPPPPP/1R2K1R1 w KQkq - 0 1" {
+		panic("CHESS960 TEST FAILED unmake " + pos.fen())
+	}
+
+	// switching the option keeps the loaded game, castles change notation
+	state := &uci_state{}
+	uci_position(state, []string{"startpos", "moves", "e2e4", "e7e5", "g1f3", "b8c6", "f1c4", "g8f6", "e1g1"})
+	uci_setoption(state, []string{"name", "UCI_Chess960", "value", "true"})
+	if state.game != nil || len(state.root.history) != 7 || state.position[len(state.position)-1] != "e1h1" {
+		panic("CHESS960 OPTION TEST FAILED")
+	}
+	uci_setoption(state, []string{"name", "UCI_Chess960", "value", "false"})
+	if state.game == nil || len(state.game.Moves()) != 7 || state.position[len(state.position)-1] != "e1g1" {
+		panic("CHESS960 OPTION TEST FAILED")
+	}
+}
+
+// placement, side to move, castling rights and en passant square of a fen
+func board_fields(fen string) string {
+	return strings.Join(strings.Fields(fen)[:4], " ")
+}
+
+func test_see(position string, move string, expected int) {
+	pos, _ := new_position(position)
+	if m := pos.parse_move(move); m == NO_MOVE || see(pos, m) != expected {
+		panic("SEE TEST FAILED " + move)
 	}
-	VERBOSE_FLAG = stored
-	fmt.Println("Tests passed...\n")
 }
 
 func setup() *chess.Game {
@@ -132,44 +406,149 @@ func setup() *chess.Game {
 	return chess.NewGame(fen)
 }
 
+// runs engine_move() in its own goroutine. Cancelling ctx aborts the search and
+// the best move of the last completed iteration is sent instead.
+func engine_async(ctx context.Context, root *Position, game *chess.Game, max bool) <-chan Move {
+	result := make(chan Move, 1)
+	finished := make(chan struct{})
+	watching := make(chan struct{})
+	atomic.StoreInt32(&stop_search, 0)
+
+	go func() {
+		defer close(watching)
+		select {
+		case <-ctx.Done():
+			atomic.StoreInt32(&stop_search, 1)
+		case <-finished:
+		}
+	}()
+
+	go func() {
+		move := engine_move(root, game, max)
+		close(finished)
+		<-watching // the watcher must not touch the flag of the next search
+		result <- move
+	}()
+	return result
+}
+
 func engine(game *chess.Game, max bool) (output *chess.Move) {
+	return to_chess_move(game, engine_move(position_from_game(game), game, max))
+}
+
+// the move for the root, the game is the same game for the opening book and
+// nil when the library can't follow it (Chess960)
+func engine_move(root *Position, game *chess.Game, max bool) (output Move) {
 
-	if opening_moves {
+	if opening_moves && game != nil {
 		move := get_opening(game, 0)
-		fmt.Println(move)
+		if VERBOSE_FLAG >= 1 {
+			fmt.Println(move)
+		}
 		if move == nil {
 			opening_moves = false
-		} else {
-			return move
+		} else if book := root.parse_move(move.String()); book != NO_MOVE {
+			return book
 		}
 		// panic("te")
 	}
 
-
+	t := main_thread()
 	explored = 0
 	init_explored_depth()
+	search_start = time.Now()
+	new_hash_search()
+	tm := new_time_manager()
 	if DO_MTDF {
-		output = iterative_deepening_mtdf(game, TIME_TO_THINK, max)
+		t.reset(root)
+		output = t.iterative_deepening_mtdf(max, tm)
 	} else if DO_ITERATIVE_DEEPENING {
-		output = iterative_deepening(game, TIME_TO_THINK, max)
+		output = search_threads(root, max, tm)
 	} else {
-		var history [mem_size]string
-		output, _, history = minimax_factory(game, 0, max)
-		fmt.Println(history)
+		t.reset(root)
+		t.depth = DEPTH
+		var line []Move
+		output, _, line = t.minimax_factory(t.pos, root.static_eval(), max)
+		fmt.Println(line)
 		print_iter_2()
 	}
 	return
 }
 
-func minimax_factory(game *chess.Game, preval int, max bool) (best *chess.Move, eval int, history [mem_size]string) {
-	if flag == 4 {
-		best, eval, history, _ = minimax_hashing(game, DEPTH, -math.MaxInt, math.MaxInt, max, preval)
+// score from the point of view of the side to move
+func relative_eval(eval int, max bool) int {
+	if max {
+		return eval
+	}
+	return -eval
+}
+
+// depth, node and stop limits checked between iterations
+func (t *Thread) search_limit_reached() bool {
+	if t.depth > depth_limit {
+		return true
+	}
+	if node_limit > 0 && explored >= node_limit {
+		return true
+	}
+	return atomic.LoadInt32(&stop_search) == 1
+}
+
+// Searches the thread's depth with a narrow window around the score of the previous
+// iteration, widening exponentially on the side that failed until the score
+// lands inside. guess and the returned eval are white relative.
+func (t *Thread) aspiration_search(pos *Position, max bool, guess int) (best Move, eval int, pv []Move) {
+	preval := pos.static_eval()
+	if !DO_ASPIRATION || t.depth <= 1 || flag < 4 || guess >= 10000 || guess <= -10000 {
+		return t.minimax_factory(pos, preval, max)
+	}
+
+	delta := ASPIRATION_WINDOW
+	center := relative_eval(guess, max)
+	alpha, beta := center-delta, center+delta
+	for {
+		var ignore bool
+		best, eval, ignore = t.minimax_hashing(pos, t.depth, alpha, beta, preval)
+		pv = t.root_pv()
+		if ignore {
+			return best, relative_eval(eval, max), pv
+		}
+
+		if eval <= alpha {
+			if t.id == 0 {
+				t.publish_counts()
+				// every move failed low, the line is only the last iteration's choice
+				print_iter_bound(relative_eval(eval, max), t.prev_pv[:Min(1, len(t.prev_pv))], max, "upperbound")
+			}
+			alpha = eval - delta
+		} else if eval >= beta {
+			if t.id == 0 {
+				t.publish_counts()
+				print_iter_bound(relative_eval(eval, max), legal_pv(pos, pv), max, "lowerbound")
+			}
+			beta = eval + delta
+		} else {
+			return best, relative_eval(eval, max), pv
+		}
+
+		delta *= 2
+		if delta > ASPIRATION_MAX_WINDOW {
+			alpha, beta = -math.MaxInt, math.MaxInt
+		}
+	}
+}
+
+func (t *Thread) minimax_factory(pos *Position, preval int, max bool) (best Move, eval int, pv []Move) {
+	if flag == 4 || flag == 5 {
+		best, eval, _ = t.minimax_hashing(pos, t.depth, -math.MaxInt, math.MaxInt, preval)
+		eval = relative_eval(eval, max)
+		pv = t.root_pv()
 	} else if flag == 3 {
-		best, eval = minimax_quiescence(game, DEPTH, -math.MaxInt, math.MaxInt, max, preval)
+		best, eval = minimax_quiescence(pos, DEPTH, -math.MaxInt, math.MaxInt, max, preval)
 	} else if flag == 2 {
-		best, eval = minimax_alpha_beta(game, DEPTH, -math.MaxInt, math.MaxInt, max, preval)
+		best, eval = minimax_alpha_beta(pos, DEPTH, -math.MaxInt, math.MaxInt, max, preval)
 	} else if flag == 1 {
-		best, eval = minimax_plain(game, DEPTH, max, preval)
+		best, eval = minimax_plain(pos, DEPTH, max, preval)
 	}
 	return
 }
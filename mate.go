@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+)
+
+/*
+
+Mate scores.
+
+A node without legal moves in check is scored by its distance from the root:
+being mated at ply p scores -MATE_SCORE + p, so a quicker mate is always
+worth more and a slower loss less. The table
+stores mates relative to the node instead, so a stored mate stays correct
+when the position comes up again at another ply.
+
+*/
+
+const MATE_SCORE int = 1000000
+const MATE_BOUND int = MATE_SCORE - 1000 // past this a score is a forced mate
+
+// score of the side to move when it's checkmated at ply
+func mated_in(ply int) int {
+	return -MATE_SCORE + ply
+}
+
+func is_mate_score(score int) bool {
+	return score >= MATE_BOUND || score <= -MATE_BOUND
+}
+
+func score_to_hash(score int, ply int) int {
+	if score >= MATE_BOUND {
+		return score + ply
+	} else if score <= -MATE_BOUND {
+		return score - ply
+	}
+	return score
+}
+
+func score_from_hash(score int, ply int) int {
+	if score >= MATE_BOUND {
+		return score - ply
+	} else if score <= -MATE_BOUND {
+		return score + ply
+	}
+	return score
+}
+
+// moves until mate from the root, negative when the side to move gets mated
+func mate_moves(score int) int {
+	if score > 0 {
+		return (MATE_SCORE - score + 1) / 2
+	}
+	return -(MATE_SCORE + score) / 2
+}
+
+// score of the side to move as UCI wants it, "cp x" or "mate n"
+func uci_score(score int) string {
+	if is_mate_score(score) {
+		return fmt.Sprintf("mate %d", mate_moves(score))
+	}
+	return fmt.Sprintf("cp %d", score)
+}
+
+// xboard reports mates as 100000 plus the number of moves
+func xboard_score(score int) int {
+	if !is_mate_score(score) {
+		return score
+	}
+	if moves := mate_moves(score); moves > 0 {
+		return 100000 + moves
+	} else {
+		return -100000 + moves
+	}
+}
@@ -8,20 +8,20 @@ import (
 	"github.com/notnil/chess"
 )
 
-func quiescence(game *chess.Game, depth int, alpha int, beta int, max bool, preval int, move_gen []*chess.Move) (best *chess.Move, eval int) {
-	moves := get_quiescence_moves(game, move_gen)
+func quiescence(pos *Position, depth int, alpha int, beta int, max bool, preval int, move_gen []Move) (best Move, eval int) {
+	moves := get_quiescence_moves(pos, move_gen)
 
 	if len(moves) == 0 {
-		return nil, preval
+		return NO_MOVE, preval
 	}
 
 	if max {
 		eval = -1 * math.MaxInt
 		for _, move := range moves {
-			post := game.Clone()
-			post.Move(move)
-			state_eval := evaluate_position(game, post, preval, move)
-			_, tempeval := minimax_quiescence(post, depth-1, alpha, beta, !max, state_eval)
+			state_eval := evaluate_position(pos, preval, move)
+			pos.make(move)
+			_, tempeval := minimax_quiescence(pos, depth-1, alpha, beta, !max, state_eval)
+			pos.unmake(move)
 			if tempeval > eval {
 				eval = tempeval
 				best = move
@@ -36,10 +36,10 @@ func quiescence(game *chess.Game, depth int, alpha int, beta int, max bool, prev
 	} else {
 		eval = math.MaxInt
 		for _, move := range moves {
-			post := game.Clone()
-			post.Move(move)
-			state_eval := evaluate_position(game, post, preval, move)
-			_, tempeval := minimax_quiescence(post, depth-1, alpha, beta, !max, state_eval)
+			state_eval := evaluate_position(pos, preval, move)
+			pos.make(move)
+			_, tempeval := minimax_quiescence(pos, depth-1, alpha, beta, !max, state_eval)
+			pos.unmake(move)
 			if tempeval < eval {
 				eval = tempeval
 				best = move
@@ -56,33 +56,34 @@ func quiescence(game *chess.Game, depth int, alpha int, beta int, max bool, prev
 	return
 }
 
-func minimax_quiescence(game *chess.Game, depth int, alpha int, beta int, max bool, preval int) (best *chess.Move, eval int) {
+func minimax_quiescence(pos *Position, depth int, alpha int, beta int, max bool, preval int) (best Move, eval int) {
 	// fmt.Println(depth)
 	explored++
 	explored_depth[DEPTH-depth]++
 
-	move_gen := game.ValidMoves()
+	var buffer [MAX_POSITION_MOVES]Move
+	move_gen := pos.legal_moves(buffer[:0])
 	if depth < MAX_QUIESCENCE {
-		return nil, preval
+		return NO_MOVE, preval
 	}
 
-	if depth <= 0 {
-		return quiescence(game, depth, alpha, beta, max, preval, move_gen)
+	if len(move_gen) == 0 {
+		return NO_MOVE, final_eval(pos)
 	}
 
-	moves := move_order(game, move_gen)
-
-	if len(moves) == 0 {
-		return nil, preval
+	if depth <= 0 {
+		return quiescence(pos, depth, alpha, beta, max, preval, move_gen)
 	}
 
+	moves := move_order(pos, move_gen)
+
 	if max {
 		eval = -1 * math.MaxInt
 		for _, move := range moves {
-			post := game.Clone()
-			post.Move(move)
-			state_eval := evaluate_position(game, post, preval, move)
-			_, tempeval := minimax_quiescence(post, depth-1, alpha, beta, !max, state_eval)
+			state_eval := evaluate_position(pos, preval, move)
+			pos.make(move)
+			_, tempeval := minimax_quiescence(pos, depth-1, alpha, beta, !max, state_eval)
+			pos.unmake(move)
 			if tempeval > eval {
 				eval = tempeval
 				best = move
@@ -97,10 +98,10 @@ func minimax_quiescence(game *chess.Game, depth int, alpha int, beta int, max bo
 	} else {
 		eval = math.MaxInt
 		for _, move := range moves {
-			post := game.Clone()
-			post.Move(move)
-			state_eval := evaluate_position(game, post, preval, move)
-			_, tempeval := minimax_quiescence(post, depth-1, alpha, beta, !max, state_eval)
+			state_eval := evaluate_position(pos, preval, move)
+			pos.make(move)
+			_, tempeval := minimax_quiescence(pos, depth-1, alpha, beta, !max, state_eval)
+			pos.unmake(move)
 			if tempeval < eval {
 				eval = tempeval
 				best = move
@@ -121,32 +122,33 @@ func minimax_quiescence(game *chess.Game, depth int, alpha int, beta int, max bo
 	return
 }
 
-func minimax_alpha_beta(game *chess.Game, depth int, alpha int, beta int, max bool, preval int) (best *chess.Move, eval int) {
+func minimax_alpha_beta(pos *Position, depth int, alpha int, beta int, max bool, preval int) (best Move, eval int) {
 	// fmt.Println(depth)
 	explored++
 	explored_depth[DEPTH-depth]++
 
 	if depth == 0 {
-		return nil, preval
+		return NO_MOVE, preval
 	}
 
-	move_gen := game.ValidMoves()
+	var buffer [MAX_POSITION_MOVES]Move
+	move_gen := pos.legal_moves(buffer[:0])
 	moves := move_gen
 	if DO_MOVE_ORDERING {
-		moves = move_order(game, move_gen)
+		moves = move_order(pos, move_gen)
 	}
 
 	if len(moves) == 0 {
-		return nil, preval
+		return NO_MOVE, final_eval(pos)
 	}
 
 	if max {
 		eval = -1 * math.MaxInt
 		for _, move := range moves {
-			post := game.Clone()
-			post.Move(move)
-			state_eval := evaluate_position(game, post, preval, move)
-			_, tempeval := minimax_alpha_beta(post, depth-1, alpha, beta, !max, state_eval)
+			state_eval := evaluate_position(pos, preval, move)
+			pos.make(move)
+			_, tempeval := minimax_alpha_beta(pos, depth-1, alpha, beta, !max, state_eval)
+			pos.unmake(move)
 			if tempeval > eval {
 				eval = tempeval
 				best = move
@@ -161,10 +163,10 @@ func minimax_alpha_beta(game *chess.Game, depth int, alpha int, beta int, max bo
 	} else {
 		eval = math.MaxInt
 		for _, move := range moves {
-			post := game.Clone()
-			post.Move(move)
-			state_eval := evaluate_position(game, post, preval, move)
-			_, tempeval := minimax_alpha_beta(post, depth-1, alpha, beta, !max, state_eval)
+			state_eval := evaluate_position(pos, preval, move)
+			pos.make(move)
+			_, tempeval := minimax_alpha_beta(pos, depth-1, alpha, beta, !max, state_eval)
+			pos.unmake(move)
 			if tempeval < eval {
 				eval = tempeval
 				best = move
@@ -184,29 +186,30 @@ func minimax_alpha_beta(game *chess.Game, depth int, alpha int, beta int, max bo
 	return
 }
 
-func minimax_plain(game *chess.Game, depth int, max bool, preval int) (best *chess.Move, eval int) {
+func minimax_plain(pos *Position, depth int, max bool, preval int) (best Move, eval int) {
 	// fmt.Println(depth)
 	explored++
 	explored_depth[DEPTH-depth]++
 
 	if depth == 0 {
-		return nil, preval
+		return NO_MOVE, preval
 	}
 
-	move_gen := game.ValidMoves()
+	var buffer [MAX_POSITION_MOVES]Move
+	move_gen := pos.legal_moves(buffer[:0])
 	moves := move_gen
 
 	if len(moves) == 0 {
-		return nil, preval
+		return NO_MOVE, final_eval(pos)
 	}
 
 	if max {
 		eval = -1 * math.MaxInt
 		for _, move := range moves {
-			post := game.Clone()
-			post.Move(move)
-			state_eval := evaluate_position(game, post, preval, move)
-			_, tempeval := minimax_plain(post, depth-1, !max, state_eval)
+			state_eval := evaluate_position(pos, preval, move)
+			pos.make(move)
+			_, tempeval := minimax_plain(pos, depth-1, !max, state_eval)
+			pos.unmake(move)
 			if tempeval > eval {
 				eval = tempeval
 				best = move
@@ -215,10 +218,10 @@ func minimax_plain(game *chess.Game, depth int, max bool, preval int) (best *che
 	} else {
 		eval = math.MaxInt
 		for _, move := range moves {
-			post := game.Clone()
-			post.Move(move)
-			state_eval := evaluate_position(game, post, preval, move)
-			_, tempeval := minimax_plain(post, depth-1, !max, state_eval)
+			state_eval := evaluate_position(pos, preval, move)
+			pos.make(move)
+			_, tempeval := minimax_plain(pos, depth-1, !max, state_eval)
+			pos.unmake(move)
 			if tempeval < eval {
 				eval = tempeval
 				best = move
@@ -243,3 +246,14 @@ func capture_only(game *chess.Game) *chess.Move {
 	move := moves[rand.Intn(len(moves))]
 	return move
 }
+
+// mates and stalemates for the old searches, white relative
+func final_eval(pos *Position) int {
+	if !pos.in_check() {
+		return 0
+	}
+	if pos.turn == chess.White {
+		return -MATE_SCORE
+	}
+	return MATE_SCORE
+}
@@ -31,25 +31,35 @@ func testopening(){
 }
 
 func get_opening(g *chess.Game, retries int) *chess.Move {
+	// the book's lines are from the normal start, Chess960 and set up positions go without it
+	if g.Positions()[0].String() != start_pos {
+		return nil
+	}
 	book := opening.NewBookECO()
 	moves := g.Moves()
 	if len(moves) == 0 && g.FEN() == "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1" {
 		var gx *chess.Game = chess.NewGame(chess.UseNotation(chess.UCINotation{}))
 		gx.MoveStr("e2e4")
-		fmt.Println(gx.Moves())
+		if VERBOSE_FLAG >= 1 {
+			fmt.Println(gx.Moves())
+		}
 		return gx.Moves()[0]
 	}
 	o := book.Find(moves) // find current opening
 	if o == nil {
 		return nil
 	}
-	fmt.Println("\nFrom:", o.Title())
+	if VERBOSE_FLAG >= 1 {
+		fmt.Println("\nFrom:", o.Title())
+	}
 	// fmt.Println(g.Moves())
 	p := book.Possible(g.Moves()) // all openings available
 	if len(p) > 0 {
 		r := p[rand.Intn(len(p))] // random opening available
-		fmt.Println("To:", r.Title())
-		fmt.Println(r.PGN())
+		if VERBOSE_FLAG >= 1 {
+			fmt.Println("To:", r.Title())
+			fmt.Println(r.PGN())
+		}
 		// pgn, err := chess.PGN(bytes.NewBufferString(r.PGN()))
 		// if err != nil {
 		// 	panic(err)
@@ -68,7 +78,9 @@ func get_opening(g *chess.Game, retries int) *chess.Move {
 		ms := gx.Moves()
 		// fmt.Println(split)
 		m := ms[len(g.Moves())]
-		fmt.Println(m)
+		if VERBOSE_FLAG >= 1 {
+			fmt.Println(m)
+		}
 		return m
 	}
 	return nil
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+
+Perft.
+
+Counts the leaf nodes of the legal move tree to a fixed depth, the standard
+check of a move generator against known numbers. divide prints the count
+below each root move, so a wrong count can be narrowed down move by move
+against another engine.
+
+	chess perft <depth> [fen]
+	chess divide <depth> [fen]
+	chess perft suite
+
+The suite has the usual positions from the chessprogramming wiki plus
+promotion, en passant and castling edge cases and Chess960 positions with
+Shredder-FEN castling rights. go test runs it as TestPerft (-short leaves out
+the big cases), run_tests runs the cheap ones.
+
+*/
+
+type perft_case struct {
+	name  string
+	fen   string
+	depth int
+	nodes int
+}
+
+var perft_suite = []perft_case{
+	{"start", start_pos, 5, 4865609},
+	{"kiwipete", "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", 4, 4085603},
+	{"position 3", "8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1", 5, 674624},
+	{"position 4", "r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1", 4, 422333},
+	{"position 4 mirrored", "r2q1rk1/pP1p2pp/Q4n2/bbp1p3/Np6/1B3NBn/pPPP1PPP/R3K2R b KQ - 0 1", 4, 422333},
+	{"position 5", "rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8", 4, 2103487},
+	{"position 6", "r4rk1/1pp1qppp/p1np1n2/2b1p1B1/2B1P1b1/P1NP1N2/1PP1QPPP/R4RK1 w - - 0 10", 4, 3894594},
+	{"promotions", "n1n5/PPPk4/8/8/8/8/4Kppp/5N1N b - - 0 1", 4, 182838},
+	{"promote out of check", "2K2r2/4P3/8/8/8/8/8/3k4 w - - 0 1", 6, 3821001},
+	{"promote to give check", "4k3/1P6/8/8/8/8/K7/8 w - - 0 1", 6, 217342},
+	{"underpromote to check", "8/P1k5/K7/8/8/8/8/8 w - - 0 1", 6, 92683},
+	{"self stalemate", "K1k5/8/P7/8/8/8/8/8 w - - 0 1", 6, 2217},
+	{"stalemate and checkmate", "8/k1P5/8/1K6/8/8/8/8 w - - 0 1", 7, 567584},
+	{"illegal en passant", "3k4/3p4/8/K1P4r/8/8/8/8 b - - 0 1", 6, 1134888},
+	{"en passant gives check", "8/8/1k6/2b5/2pP4/8/5K2/8 b - d3 0 1", 6, 1440467},
+	{"illegal en passant 2", "8/8/4k3/8/2p5/8/B2P2K1/8 w - - 0 1", 6, 1015133},
+	{"short castle gives check", "5k2/8/8/8/8/8/8/4K2R w K - 0 1", 6, 661072},
+	{"long castle gives check", "3k4/8/8/8/8/8/8/R3K3 w Q - 0 1", 6, 803711},
+	{"castling rights", "r3k2r/1b4bq/8/8/8/8/7B/R3K2R w KQkq - 0 1", 4, 1274206},
+	{"castling prevented", "r3k2r/8/3Q4/8/8/5q2/8/R3K2R b KQkq - 0 1", 4, 1720476},
+	{"discovered check", "8/8/1P2K3/8/2n5/1q6/8/5k2 b - - 0 1", 5, 1004658},
+	{"double check", "8/8/2k5/5q2/5n2/8/5K2/8 b - - 0 1", 4, 23527},
+	{"chess960 1", "bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9", 4, 326672},
+	{"chess960 2", "2nnrbkr/p1qppppp/8/1ppb4/6PP/3PP3/PPP2P2/BQNNRBKR w HEhe - 1 9", 5, 16253601},
+	{"chess960 3", "b1q1rrkb/pppppppp/3nn3/8/P7/1PPP4/4PPPP/BQNNRKRB w GE - 1 9", 4, 273318},
+	{"chess960 4", "qbbnnrkr/2pp2pp/p7/1p2pp2/8/P3PP2/1PPP1KPP/QBBNNR1R w hf - 0 9", 4, 382958},
+	{"chess960 5", "1nbbnrkr/p1p1ppp1/3p4/1p3P1p/3Pq2P/8/PPP1P1P1/QNBBNRKR w HFhf - 0 9", 5, 34030312},
+}
+
+func perft(pos *Position, depth int) int {
+	var buffer [MAX_POSITION_MOVES]Move
+	moves := pos.legal_moves(buffer[:0])
+	if depth <= 1 {
+		if depth <= 0 {
+			return 1
+		}
+		return len(moves)
+	}
+	nodes := 0
+	for _, move := range moves {
+		pos.make(move)
+		nodes += perft(pos, depth-1)
+		pos.unmake(move)
+	}
+	return nodes
+}
+
+// perft below every root move, printed like other engines do
+func divide(pos *Position, depth int) int {
+	var buffer [MAX_POSITION_MOVES]Move
+	nodes := 0
+	for _, move := range pos.legal_moves(buffer[:0]) {
+		pos.make(move)
+		count := perft(pos, depth-1)
+		pos.unmake(move)
+		fmt.Printf("%s: %d\n", move, count)
+		nodes += count
+	}
+	return nodes
+}
+
+// command line: perft|divide <depth> [fen], or perft suite
+func perft_command(args []string) {
+	generateZobristConstants()
+	if args[0] == "perft" && len(args) > 1 && args[1] == "suite" {
+		if !run_perft_suite(0, true) {
+			os.Exit(1)
+		}
+		return
+	}
+	if len(args) < 2 {
+		fmt.Println("usage:", args[0], "<depth> [fen]")
+		os.Exit(2)
+	}
+	depth, err := strconv.Atoi(args[1])
+	fen := start_pos
+	if len(args) > 2 {
+		fen = strings.Join(args[2:], " ")
+	}
+	pos, fen_err := new_position(fen)
+	if err != nil || depth < 0 || fen_err != nil {
+		fmt.Println("usage:", args[0], "<depth> [fen]")
+		os.Exit(2)
+	}
+
+	start := time.Now()
+	var nodes int
+	if args[0] == "divide" {
+		nodes = divide(pos, depth)
+		fmt.Println()
+	} else {
+		nodes = perft(pos, depth)
+	}
+	print_perft(nodes, time.Since(start))
+}
+
+func print_perft(nodes int, elapsed time.Duration) {
+	nps := 0
+	if elapsed > 0 {
+		nps = int(float64(nodes) / elapsed.Seconds())
+	}
+	fmt.Printf("nodes %d time %d nps %d\n", nodes, elapsed.Milliseconds(), nps)
+}
+
+// runs the cases with at most max_nodes nodes (0 for all), returns false if any count is off
+func run_perft_suite(max_nodes int, verbose bool) bool {
+	passed := true
+	total := 0
+	start := time.Now()
+	for _, c := range perft_suite {
+		if max_nodes > 0 && c.nodes > max_nodes {
+			continue
+		}
+		pos, _ := new_position(c.fen)
+		nodes := perft(pos, c.depth)
+		total += nodes
+		if nodes != c.nodes {
+			passed = false
+		}
+		if verbose || nodes != c.nodes {
+			result := "ok"
+			if nodes != c.nodes {
+				result = fmt.Sprintf("FAILED, expected %d", c.nodes)
+			}
+			fmt.Printf("%-24s depth %d nodes %9d %s\n", c.name, c.depth, nodes, result)
+		}
+	}
+	if verbose {
+		print_perft(total, time.Since(start))
+	}
+	return passed
+}
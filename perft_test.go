@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+)
+
+// the whole suite, go test -short leaves out the cases past a million nodes
+func TestPerft(t *testing.T) {
+	generateZobristConstants()
+	for _, c := range perft_suite {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			if testing.Short() && c.nodes > 1000000 {
+				t.Skip("skipped in short mode,", c.nodes, "nodes")
+			}
+			pos, err := new_position(c.fen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nodes := perft(pos, c.depth); nodes != c.nodes {
+				t.Errorf("depth %d: %d nodes, expected %d", c.depth, nodes, c.nodes)
+			}
+		})
+	}
+}
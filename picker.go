@@ -0,0 +1,247 @@
+package main
+
+import (
+	"github.com/notnil/chess"
+)
+
+/*
+
+Staged move picker.
+
+Hands out the moves of a node one at a time, cheapest to find and most
+likely to cut off first, so a node that cuts off early never generates the
+rest:
+
+	1. the table move and the previous iteration's move, checked on their own
+	2. captures and promotions that don't lose material by SEE, MVV-LVA breaks ties
+	3. both killers and the countermove
+	4. quiet moves by history
+	5. captures that lose material
+
+Moves are pseudo-legal, the search makes them and skips the ones that leave
+the king in check. The lists live in the thread's buffer of the ply.
+
+Quiescence only takes stages 1 and 2 (plus quiet checks with checks set),
+out of check it gets every move.
+
+*/
+
+const (
+	PICK_FIRST = iota
+	PICK_GENERATE_CAPTURES
+	PICK_GOOD_CAPTURES
+	PICK_KILLERS
+	PICK_GENERATE_QUIETS
+	PICK_QUIETS
+	PICK_BAD_CAPTURES
+	PICK_DONE
+)
+
+type MovePicker struct {
+	pos     *Position
+	t       *Thread
+	ply     int
+	stage   int
+	quiet   bool    // false in quiescence
+	checks  bool    // quiet checks in quiescence
+	firsts  [2]Move // table move, previous iteration's move
+	special [3]Move // killers and countermove
+	index   int     // next of firsts or special
+	moves   []Move  // the ply's buffer, bad captures are kept at the front
+	scores  [MAX_POSITION_MOVES]int
+	bad     int // bad captures found so far
+	current int // next move of the stage
+	end     int // end of the stage's moves
+}
+
+func (t *Thread) new_picker(pos *Position, ply int, hashmove uint16, quiet bool, checks bool) MovePicker {
+	p := MovePicker{pos: pos, t: t, ply: ply, quiet: quiet, checks: checks, moves: t.moves[ply][:]}
+	p.firsts[0] = p.usable(pos.pseudo_legal(hashmove))
+	if pv := t.pv_move(pos, ply); pv != NO_MOVE && !same_move(pv, p.firsts[0]) {
+		p.firsts[1] = p.usable(pos.pseudo_legal(encode_move(pv)))
+	}
+	return p
+}
+
+// quiescence only searches moves that the noisy stages would give it
+func (p *MovePicker) usable(move Move) Move {
+	if move == NO_MOVE || p.quiet {
+		return move
+	}
+	if !is_quiet(move) || (p.checks && p.pos.gives_check(move)) {
+		if !DO_SEE_PRUNING || see(p.pos, move) >= 0 {
+			return move
+		}
+	}
+	return NO_MOVE
+}
+
+// moves handed out in the first stages aren't repeated
+func (p *MovePicker) picked(move Move) bool {
+	for _, first := range p.firsts {
+		if same_move(move, first) {
+			return true
+		}
+	}
+	if p.stage > PICK_KILLERS {
+		for _, special := range p.special {
+			if same_move(move, special) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// the countermove is often one of the killers
+func (p *MovePicker) repeated(i int) bool {
+	for _, special := range p.special[:i] {
+		if same_move(special, p.special[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// the next pseudo-legal move, NO_MOVE once there are none left
+func (p *MovePicker) next() Move {
+	for {
+		switch p.stage {
+		case PICK_FIRST:
+			for p.index < len(p.firsts) {
+				move := p.firsts[p.index]
+				p.index++
+				if move != NO_MOVE {
+					return move
+				}
+			}
+			p.stage++
+
+		case PICK_GENERATE_CAPTURES:
+			p.end = len(p.pos.generate(p.moves[:0], true, false))
+			for i := 0; i < p.end; i++ {
+				p.scores[i] = 100*see(p.pos, p.moves[i]) + mvv_lva(p.pos, p.moves[i])
+			}
+			p.stage++
+
+		case PICK_GOOD_CAPTURES:
+			for p.current < p.end {
+				move, score := p.pick_best()
+				if p.picked(move) {
+					continue
+				}
+				if score < 0 {
+					// losing captures wait at the front of the buffer for the last stage
+					p.moves[p.bad] = move
+					p.bad++
+					continue
+				}
+				return move
+			}
+			p.stage++
+			p.index = 0
+			if !p.quiet {
+				p.stage = PICK_GENERATE_QUIETS
+				if !p.checks {
+					p.stage = PICK_BAD_CAPTURES
+					p.current = 0
+				}
+				break
+			}
+			if p.ply < mem_size {
+				p.special[0], p.special[1] = p.t.killer_moves[p.ply][0], p.t.killer_moves[p.ply][1]
+			}
+			if previous := p.pos.last_move(); previous != NO_MOVE {
+				p.special[2] = p.t.countermove_table[side_index(p.pos.turn)][previous.from()][previous.to()]
+			}
+
+		case PICK_KILLERS:
+			for p.index < len(p.special) {
+				move := p.special[p.index]
+				p.index++
+				if move == NO_MOVE || p.picked(move) || p.repeated(p.index-1) {
+					continue
+				}
+				if move = p.pos.pseudo_legal(encode_move(move)); move != NO_MOVE && is_quiet(move) {
+					return move
+				}
+			}
+			p.stage++
+
+		case PICK_GENERATE_QUIETS:
+			// after the bad captures, which are all that's left of the captures
+			p.current = p.bad
+			p.end = len(p.pos.generate(p.moves[:p.bad], false, true))
+			side := side_index(p.pos.turn)
+			for i := p.current; i < p.end; i++ {
+				move := p.moves[i]
+				p.scores[i] = p.t.history_table[side][move.from()][move.to()] + quiet_position_gain(p.pos, move)
+			}
+			p.stage++
+
+		case PICK_QUIETS:
+			for p.current < p.end {
+				move, _ := p.pick_best()
+				if p.picked(move) {
+					continue
+				}
+				if !p.quiet && !(p.pos.gives_check(move) && (!DO_SEE_PRUNING || see(p.pos, move) >= 0)) {
+					continue
+				}
+				return move
+			}
+			p.stage++
+			p.current = 0
+
+		case PICK_BAD_CAPTURES:
+			if !p.quiet && DO_SEE_PRUNING {
+				p.stage++
+				break
+			}
+			if p.current < p.bad {
+				p.current++
+				return p.moves[p.current-1]
+			}
+			p.stage++
+
+		default:
+			return NO_MOVE
+		}
+	}
+}
+
+// selection sort step: swaps the best remaining move to the front and hands it out
+func (p *MovePicker) pick_best() (Move, int) {
+	best := p.current
+	for i := p.current + 1; i < p.end; i++ {
+		if p.scores[i] > p.scores[best] {
+			best = i
+		}
+	}
+	p.moves[p.current], p.moves[best] = p.moves[best], p.moves[p.current]
+	p.scores[p.current], p.scores[best] = p.scores[best], p.scores[p.current]
+	p.current++
+	return p.moves[p.current-1], p.scores[p.current-1]
+}
+
+// most valuable victim first, least valuable attacker among those, 0 to 45 so
+// it only breaks ties between equal exchanges. Piece types count down from king to pawn.
+func mvv_lva(pos *Position, move Move) int {
+	victim := pos.squares[move.to()].Type()
+	if move.has(EN_PASSANT_FLAG) {
+		victim = chess.Pawn
+	}
+	if victim == chess.NoPieceType {
+		return 0
+	}
+	return 8*(6-int(victim)) + int(pos.squares[move.from()].Type()) - 1
+}
+
+// piece square table gain of a quiet move, orders the moves history knows nothing about
+func quiet_position_gain(pos *Position, move Move) int {
+	piece := pos.squares[move.from()].Type()
+	max := pos.turn == chess.White
+	from := get_pos_val(piece, int8(move.from().File()), int8(move.from().Rank()), max)
+	to := get_pos_val(piece, int8(move.target().File()), int8(move.target().Rank()), max)
+	return to - from
+}
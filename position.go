@@ -0,0 +1,806 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/notnil/chess"
+)
+
+/*
+
+Position.
+
+The board the search runs on: a bitboard per piece and per color plus a
+mailbox, changed in place by make and restored by unmake. make pushes what
+can't be recomputed on the way back (the captured piece, castling rights, en
+passant square, fifty move counter and key) on the position's history, which
+also tells the search which moves led to a node.
+
+notnil/chess is only used at the edges: positions come in as FEN, moves go
+back out through their UCI strings.
+
+Castling is stored as the king taking its own rook, which covers Chess960
+where the king and rooks may start anywhere on the back rank. Each right
+keeps the square of its rook, the FEN field may name them by file
+(Shredder-FEN and X-FEN).
+
+*/
+
+const MAX_POSITION_MOVES int = 256 // more than any position has
+
+// from | to << 6 | promotion << 12 | flags
+type Move uint32
+
+const NO_MOVE Move = 0 // also stands for the null move in the history
+
+const (
+	CAPTURE_FLAG Move = 1 << (16 + iota)
+	EN_PASSANT_FLAG
+	CASTLE_FLAG
+	DOUBLE_PUSH_FLAG
+)
+
+const (
+	CASTLE_WHITE_KING uint8 = 1 << iota
+	CASTLE_WHITE_QUEEN
+	CASTLE_BLACK_KING
+	CASTLE_BLACK_QUEEN
+)
+
+type undo struct {
+	move     Move
+	captured chess.Piece
+	castle   uint8
+	ep       chess.Square
+	fifty    int
+	key      uint64
+}
+
+type Position struct {
+	pieces   [13]Bitboard // by chess.Piece
+	colors   [3]Bitboard  // by chess.Color
+	squares  [64]chess.Piece
+	turn     chess.Color
+	castle   uint8        // CASTLE_ bits
+	ep       chess.Square // square a pawn just skipped, NoSquare if none
+	fifty    int          // plies since the last capture or pawn move
+	fullmove int
+	key      uint64
+	history  []undo
+
+	castle_rooks [4]chess.Square // rook of each castling right
+	castle_mask  [64]uint8       // rights that survive a move from or to each square
+}
+
+func new_move(from chess.Square, to chess.Square, promo chess.PieceType, flags Move) Move {
+	return Move(from) | Move(to)<<6 | Move(promo)<<12 | flags
+}
+
+func (m Move) from() chess.Square {
+	return chess.Square(m & 63)
+}
+
+func (m Move) to() chess.Square {
+	return chess.Square(m >> 6 & 63)
+}
+
+func (m Move) promo() chess.PieceType {
+	return chess.PieceType(m >> 12 & 15)
+}
+
+func (m Move) has(flag Move) bool {
+	return m&flag != 0
+}
+
+// where the moving piece ends up, the king's square for castles
+func (m Move) target() chess.Square {
+	if m.has(CASTLE_FLAG) {
+		king_to, _, _ := castle_squares(m)
+		return king_to
+	}
+	return m.to()
+}
+
+// uci notation, castles are written as the king taking the rook with UCI_Chess960
+func (m Move) String() string {
+	if m == NO_MOVE {
+		return "0000"
+	}
+	to := m.to()
+	if !CHESS960 {
+		to = m.target()
+	}
+	return m.from().String() + to.String() + m.promo().String()
+}
+
+// ------ conversion -------
+
+func new_position(fen string) (*Position, error) {
+	fields := strings.Fields(fen)
+	if len(fields) < 4 {
+		return nil, errors.New("invalid fen: " + fen)
+	}
+	pos := &Position{ep: chess.NoSquare, fullmove: 1, history: make([]undo, 0, MAX_POSITION_MOVES)}
+	for i := range pos.castle_mask {
+		pos.castle_mask[i] = 15
+	}
+
+	rank, file := 7, 0
+	for _, c := range fields[0] {
+		switch {
+		case c == '/':
+			rank, file = rank-1, 0
+		case c >= '1' && c <= '8':
+			file += int(c - '0')
+		default:
+			piece := piece_from_char(c)
+			sq, ok := square_at(file, rank)
+			if piece == chess.NoPiece || !ok {
+				return nil, errors.New("invalid fen: " + fen)
+			}
+			pos.put_piece(piece, sq)
+			file++
+		}
+	}
+	if pos.pieces[chess.WhiteKing].count() != 1 || pos.pieces[chess.BlackKing].count() != 1 {
+		return nil, errors.New("invalid fen: " + fen)
+	}
+
+	pos.turn = chess.White
+	if fields[1] == "b" {
+		pos.turn = chess.Black
+	}
+	for _, c := range fields[2] {
+		if c != '-' {
+			pos.add_castle_right(c)
+		}
+	}
+	if len(fields[3]) == 2 {
+		if sq, ok := square_at(int(fields[3][0]-'a'), int(fields[3][1]-'1')); ok {
+			pos.ep = sq
+		}
+	}
+	if len(fields) >= 6 {
+		pos.fifty, _ = strconv.Atoi(fields[4])
+		pos.fullmove, _ = strconv.Atoi(fields[5])
+	}
+	pos.key = pos.compute_key()
+	return pos, nil
+}
+
+func position_from_chess(p *chess.Position) *Position {
+	pos, _ := new_position(p.String())
+	return pos
+}
+
+// replays the game from its first position, so the history holds all of it
+func position_from_game(game *chess.Game) *Position {
+	pos := position_from_chess(game.Positions()[0])
+	for _, move := range game.Moves() {
+		pos.make(pos.parse_move(move.String()))
+	}
+	return pos
+}
+
+// a position of its own with the same history, for a search thread
+func (pos *Position) copy() *Position {
+	c := *pos
+	c.history = append(make([]undo, 0, len(pos.history)+MAX_POSITION_MOVES), pos.history...)
+	return &c
+}
+
+func (pos *Position) fen() string {
+	var b strings.Builder
+	for rank := 7; rank >= 0; rank-- {
+		empty := 0
+		for file := 0; file < 8; file++ {
+			piece := pos.squares[rank*8+file]
+			if piece == chess.NoPiece {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				b.WriteString(strconv.Itoa(empty))
+				empty = 0
+			}
+			b.WriteString(piece_char(piece))
+		}
+		if empty > 0 {
+			b.WriteString(strconv.Itoa(empty))
+		}
+		if rank > 0 {
+			b.WriteByte('/')
+		}
+	}
+	b.WriteString(" " + pos.turn.String() + " ")
+
+	rights := ""
+	for i := 0; i < 4; i++ {
+		if pos.castle&(1<<i) != 0 {
+			rights += pos.castle_char(i)
+		}
+	}
+	if rights == "" {
+		rights = "-"
+	}
+	ep := "-"
+	if pos.ep != chess.NoSquare {
+		ep = pos.ep.String()
+	}
+	b.WriteString(rights + " " + ep + " " + strconv.Itoa(pos.fifty) + " " + strconv.Itoa(pos.fullmove))
+	return b.String()
+}
+
+// K, Q, k and q take the outermost rook on that side of the king, file letters
+// name the rook. Rights without a king and rook on the back rank are dropped.
+func (pos *Position) add_castle_right(c rune) {
+	color, letter := chess.White, c
+	if c >= 'a' && c <= 'z' {
+		color, letter = chess.Black, c-'a'+'A'
+	}
+	rank := chess.Square(0)
+	if color == chess.Black {
+		rank = 56
+	}
+	king := pos.king_square(color)
+	if king&^7 != rank {
+		return
+	}
+	var rook chess.Square
+	switch {
+	case letter == 'K':
+		rook = pos.outermost_rook(color, true)
+	case letter == 'Q':
+		rook = pos.outermost_rook(color, false)
+	case letter >= 'A' && letter <= 'H':
+		rook = rank + chess.Square(letter-'A')
+	default:
+		return
+	}
+	if rook == chess.NoSquare || pos.squares[rook] != color_piece(chess.Rook, color) {
+		return
+	}
+	i := 2 * side_index(color)
+	if rook < king {
+		i++
+	}
+	pos.castle |= 1 << i
+	pos.castle_rooks[i] = rook
+	pos.castle_mask[king] &^= 3 << (2 * side_index(color))
+	pos.castle_mask[rook] &^= 1 << i
+}
+
+// the rook furthest from the king on one side of it, NoSquare if there is none
+func (pos *Position) outermost_rook(color chess.Color, kingside bool) chess.Square {
+	king := pos.king_square(color)
+	rooks := pos.bitboard(chess.Rook, color) & (RANK_1 << (king &^ 7))
+	if kingside {
+		rooks &^= square_bb(king)<<1 - 1
+		if rooks != 0 {
+			return rooks.msb()
+		}
+	} else {
+		rooks &= square_bb(king) - 1
+		if rooks != 0 {
+			return rooks.lsb()
+		}
+	}
+	return chess.NoSquare
+}
+
+// X-FEN: the letter of the side unless another rook stands further out, then the file
+func (pos *Position) castle_char(i int) string {
+	color := chess.White
+	if i >= 2 {
+		color = chess.Black
+	}
+	rook := pos.castle_rooks[i]
+	c := string("KQ"[i%2])
+	if pos.outermost_rook(color, i%2 == 0) != rook {
+		c = string(rune('A' + rook.File()))
+	}
+	if color == chess.Black {
+		return strings.ToLower(c)
+	}
+	return c
+}
+
+func piece_from_char(c rune) chess.Piece {
+	for i, p := range "KQRBNPkqrbnp" {
+		if p == c {
+			return chess.Piece(i + 1)
+		}
+	}
+	return chess.NoPiece
+}
+
+func piece_char(piece chess.Piece) string {
+	return string("KQRBNPkqrbnp"[piece-1])
+}
+
+// the legal move with the uci string, NO_MOVE if there is none
+func (pos *Position) parse_move(s string) Move {
+	var buffer [MAX_POSITION_MOVES]Move
+	for _, move := range pos.legal_moves(buffer[:0]) {
+		if move.String() == s {
+			return move
+		}
+	}
+	return NO_MOVE
+}
+
+// the library's move for a move of the game's current position
+func to_chess_move(game *chess.Game, move Move) *chess.Move {
+	if move == NO_MOVE {
+		return nil
+	}
+	return find_uci_move(game.Position(), move.String())
+}
+
+// ------ board -------
+
+func (pos *Position) put_piece(piece chess.Piece, sq chess.Square) {
+	pos.pieces[piece] |= square_bb(sq)
+	pos.colors[piece.Color()] |= square_bb(sq)
+	pos.squares[sq] = piece
+}
+
+func (pos *Position) remove_piece(sq chess.Square) {
+	piece := pos.squares[sq]
+	pos.pieces[piece] &^= square_bb(sq)
+	pos.colors[piece.Color()] &^= square_bb(sq)
+	pos.squares[sq] = chess.NoPiece
+}
+
+func (pos *Position) occupied() Bitboard {
+	return pos.colors[chess.White] | pos.colors[chess.Black]
+}
+
+func (pos *Position) bitboard(p chess.PieceType, color chess.Color) Bitboard {
+	return pos.pieces[color_piece(p, color)]
+}
+
+func (pos *Position) king_square(color chess.Color) chess.Square {
+	return pos.bitboard(chess.King, color).lsb()
+}
+
+// pieces of both colors attacking a square, sliders see through what isn't in occupied
+func (pos *Position) attackers_to(sq chess.Square, occupied Bitboard) Bitboard {
+	diagonal := pos.pieces[chess.WhiteBishop] | pos.pieces[chess.BlackBishop] | pos.pieces[chess.WhiteQueen] | pos.pieces[chess.BlackQueen]
+	straight := pos.pieces[chess.WhiteRook] | pos.pieces[chess.BlackRook] | pos.pieces[chess.WhiteQueen] | pos.pieces[chess.BlackQueen]
+	return pawn_attacks[1][sq]&pos.pieces[chess.WhitePawn] |
+		pawn_attacks[0][sq]&pos.pieces[chess.BlackPawn] |
+		knight_attacks[sq]&(pos.pieces[chess.WhiteKnight]|pos.pieces[chess.BlackKnight]) |
+		king_attacks[sq]&(pos.pieces[chess.WhiteKing]|pos.pieces[chess.BlackKing]) |
+		bishop_attacks(sq, occupied)&diagonal |
+		rook_attacks(sq, occupied)&straight
+}
+
+func (pos *Position) attacked(sq chess.Square, by chess.Color) bool {
+	return pos.attackers_to(sq, pos.occupied())&pos.colors[by] != 0
+}
+
+func (pos *Position) in_check() bool {
+	return pos.attacked(pos.king_square(pos.turn), pos.turn.Other())
+}
+
+// true if the color has anything besides pawns and the king
+func (pos *Position) has_pieces(color chess.Color) bool {
+	return pos.colors[color]&^(pos.bitboard(chess.Pawn, color)|pos.bitboard(chess.King, color)) != 0
+}
+
+// no side can mate anymore: bare kings, one minor piece, or bishops on the same color
+func (pos *Position) insufficient_material() bool {
+	heavy := pos.pieces[chess.WhitePawn] | pos.pieces[chess.BlackPawn] | pos.pieces[chess.WhiteRook] |
+		pos.pieces[chess.BlackRook] | pos.pieces[chess.WhiteQueen] | pos.pieces[chess.BlackQueen]
+	if heavy != 0 {
+		return false
+	}
+	knights := pos.pieces[chess.WhiteKnight] | pos.pieces[chess.BlackKnight]
+	bishops := pos.pieces[chess.WhiteBishop] | pos.pieces[chess.BlackBishop]
+	if (knights | bishops).count() <= 1 {
+		return true
+	}
+	return knights == 0 && (bishops&DARK_SQUARES == 0 || bishops&^DARK_SQUARES == 0)
+}
+
+// the move that led to the position, NO_MOVE at the start or after a null move
+func (pos *Position) last_move() Move {
+	if len(pos.history) == 0 {
+		return NO_MOVE
+	}
+	return pos.history[len(pos.history)-1].move
+}
+
+// where the pawn taken en passant stands
+func en_passant_victim(to chess.Square, color chess.Color) chess.Square {
+	if color == chess.White {
+		return to - 8
+	}
+	return to + 8
+}
+
+// where the king goes and the rook goes from and to when castling, the
+// king ends up on the g or c file and the rook next to it like in chess
+func castle_squares(move Move) (king_to chess.Square, rook_from chess.Square, rook_to chess.Square) {
+	rank := move.from() &^ 7
+	rook_from = move.to()
+	if rook_from > move.from() {
+		return rank + 6, rook_from, rank + 5
+	}
+	return rank + 2, rook_from, rank + 3
+}
+
+// ------ make / unmake -------
+
+func (pos *Position) make(move Move) {
+	from, to := move.from(), move.to()
+	piece := pos.squares[from]
+	us := pos.turn
+
+	captured := pos.squares[to]
+	if move.has(EN_PASSANT_FLAG) {
+		captured = pos.squares[en_passant_victim(to, us)]
+	} else if move.has(CASTLE_FLAG) {
+		captured = chess.NoPiece // the own rook
+	}
+	pos.history = append(pos.history, undo{move, captured, pos.castle, pos.ep, pos.fifty, pos.key})
+	key := pos.key ^ whiteToMoveZobrist ^ castle_key(pos.castle) ^ pos.en_passant_key()
+
+	if move.has(EN_PASSANT_FLAG) {
+		victim := en_passant_victim(to, us)
+		key ^= piece_key(captured, victim)
+		pos.remove_piece(victim)
+	} else if captured != chess.NoPiece {
+		key ^= piece_key(captured, to)
+		pos.remove_piece(to)
+	}
+
+	if move.has(CASTLE_FLAG) {
+		// both leave first, in Chess960 either may land where the other stood
+		king_to, rook_from, rook_to := castle_squares(move)
+		rook := pos.squares[rook_from]
+		pos.remove_piece(from)
+		pos.remove_piece(rook_from)
+		pos.put_piece(piece, king_to)
+		pos.put_piece(rook, rook_to)
+		key ^= piece_key(piece, from) ^ piece_key(piece, king_to) ^ piece_key(rook, rook_from) ^ piece_key(rook, rook_to)
+	} else {
+		pos.remove_piece(from)
+		key ^= piece_key(piece, from)
+		if move.promo() != chess.NoPieceType {
+			piece = color_piece(move.promo(), us)
+		}
+		pos.put_piece(piece, to)
+		key ^= piece_key(piece, to)
+	}
+
+	pos.castle &= pos.castle_mask[from] & pos.castle_mask[to]
+	pos.ep = chess.NoSquare
+	if move.has(DOUBLE_PUSH_FLAG) {
+		pos.ep = (from + to) / 2
+	}
+	pos.fifty++
+	if captured != chess.NoPiece || piece.Type() == chess.Pawn || move.promo() != chess.NoPieceType {
+		pos.fifty = 0
+	}
+	if us == chess.Black {
+		pos.fullmove++
+	}
+	pos.turn = us.Other()
+
+	pos.key = key ^ castle_key(pos.castle) ^ pos.en_passant_key()
+	if DO_ZOBRIST_CHECK && pos.key != pos.compute_key() {
+		panic("ZOBRIST MISMATCH " + pos.fen())
+	}
+}
+
+func (pos *Position) unmake(move Move) {
+	last := pos.history[len(pos.history)-1]
+	pos.history = pos.history[:len(pos.history)-1]
+	pos.turn = pos.turn.Other()
+	us := pos.turn
+	if us == chess.Black {
+		pos.fullmove--
+	}
+	from, to := move.from(), move.to()
+
+	if move.has(CASTLE_FLAG) {
+		king_to, rook_from, rook_to := castle_squares(move)
+		king, rook := pos.squares[king_to], pos.squares[rook_to]
+		pos.remove_piece(king_to)
+		pos.remove_piece(rook_to)
+		pos.put_piece(king, from)
+		pos.put_piece(rook, rook_from)
+		pos.castle, pos.ep, pos.fifty, pos.key = last.castle, last.ep, last.fifty, last.key
+		return
+	}
+
+	piece := pos.squares[to]
+	if move.promo() != chess.NoPieceType {
+		piece = color_piece(chess.Pawn, us)
+	}
+	pos.remove_piece(to)
+	pos.put_piece(piece, from)
+
+	if move.has(EN_PASSANT_FLAG) {
+		pos.put_piece(last.captured, en_passant_victim(to, us))
+	} else if last.captured != chess.NoPiece {
+		pos.put_piece(last.captured, to)
+	}
+
+	pos.castle, pos.ep, pos.fifty, pos.key = last.castle, last.ep, last.fifty, last.key
+}
+
+// passes the turn, the history gets a NO_MOVE entry
+func (pos *Position) make_null() {
+	pos.history = append(pos.history, undo{NO_MOVE, chess.NoPiece, pos.castle, pos.ep, pos.fifty, pos.key})
+	pos.key ^= whiteToMoveZobrist ^ pos.en_passant_key()
+	pos.ep = chess.NoSquare
+	pos.fifty++
+	pos.turn = pos.turn.Other()
+}
+
+func (pos *Position) unmake_null() {
+	last := pos.history[len(pos.history)-1]
+	pos.history = pos.history[:len(pos.history)-1]
+	pos.turn = pos.turn.Other()
+	pos.ep, pos.fifty, pos.key = last.ep, last.fifty, last.key
+}
+
+// ------ move generation -------
+
+// Appends the pseudo-legal moves: captures and promotions (noisy), the other
+// moves (quiet) or both. Only castling is checked for attacked squares, the
+// rest may still leave the king in check.
+func (pos *Position) generate(moves []Move, noisy bool, quiet bool) []Move {
+	us, them := pos.turn, pos.turn.Other()
+	occupied := pos.occupied()
+	enemies := pos.colors[them]
+	targets := Bitboard(0)
+	if noisy {
+		targets |= enemies
+	}
+	if quiet {
+		targets |= ^occupied
+	}
+
+	moves = pos.generate_pawn_moves(moves, noisy, quiet)
+	for _, p := range []chess.PieceType{chess.Knight, chess.Bishop, chess.Rook, chess.Queen, chess.King} {
+		pieces := pos.bitboard(p, us)
+		for pieces != 0 {
+			from := pieces.pop()
+			attacks := piece_attacks(p, us, from, occupied) & targets
+			for attacks != 0 {
+				to := attacks.pop()
+				if enemies&square_bb(to) != 0 {
+					moves = append(moves, new_move(from, to, chess.NoPieceType, CAPTURE_FLAG))
+				} else {
+					moves = append(moves, new_move(from, to, chess.NoPieceType, 0))
+				}
+			}
+		}
+	}
+	if quiet {
+		moves = pos.generate_castles(moves)
+	}
+	return moves
+}
+
+func (pos *Position) generate_pawn_moves(moves []Move, noisy bool, quiet bool) []Move {
+	us, them := pos.turn, pos.turn.Other()
+	empty := ^pos.occupied()
+	up, start, last := 8, RANK_2, RANK_8
+	if us == chess.Black {
+		up, start, last = -8, RANK_7, RANK_1
+	}
+
+	pawns := pos.bitboard(chess.Pawn, us)
+	for pawns != 0 {
+		from := pawns.pop()
+		one := chess.Square(int(from) + up)
+		if empty&square_bb(one) != 0 {
+			if last&square_bb(one) != 0 {
+				if noisy {
+					moves = append_promotions(moves, from, one, 0)
+				}
+			} else if quiet {
+				moves = append(moves, new_move(from, one, chess.NoPieceType, 0))
+				two := chess.Square(int(one) + up)
+				if start&square_bb(from) != 0 && empty&square_bb(two) != 0 {
+					moves = append(moves, new_move(from, two, chess.NoPieceType, DOUBLE_PUSH_FLAG))
+				}
+			}
+		}
+		if !noisy {
+			continue
+		}
+		attacks := pawn_attacks[side_index(us)][from] & pos.colors[them]
+		for attacks != 0 {
+			to := attacks.pop()
+			if last&square_bb(to) != 0 {
+				moves = append_promotions(moves, from, to, CAPTURE_FLAG)
+			} else {
+				moves = append(moves, new_move(from, to, chess.NoPieceType, CAPTURE_FLAG))
+			}
+		}
+		if pos.ep != chess.NoSquare && pawn_attacks[side_index(us)][from]&square_bb(pos.ep) != 0 {
+			moves = append(moves, new_move(from, pos.ep, chess.NoPieceType, CAPTURE_FLAG|EN_PASSANT_FLAG))
+		}
+	}
+	return moves
+}
+
+func append_promotions(moves []Move, from chess.Square, to chess.Square, flags Move) []Move {
+	for _, p := range []chess.PieceType{chess.Queen, chess.Knight, chess.Rook, chess.Bishop} {
+		moves = append(moves, new_move(from, to, p, flags))
+	}
+	return moves
+}
+
+// Every square the king and rook cross or land on has to be empty but for the
+// two of them, and the king may not castle out of, through or into check. In
+// Chess960 the rook may be what shields the king's new square before it
+// moves, the legality check after make catches that.
+func (pos *Position) generate_castles(moves []Move) []Move {
+	us, them := pos.turn, pos.turn.Other()
+	king := pos.king_square(us)
+	for i := 2 * side_index(us); i < 2*side_index(us)+2; i++ {
+		if pos.castle&(1<<i) == 0 {
+			continue
+		}
+		move := new_move(king, pos.castle_rooks[i], chess.NoPieceType, CASTLE_FLAG)
+		king_to, rook_from, rook_to := castle_squares(move)
+		others := pos.occupied() &^ square_bb(king) &^ square_bb(rook_from)
+		if others&(rank_span(king, king_to)|rank_span(rook_from, rook_to)) != 0 {
+			continue
+		}
+		path := rank_span(king, king_to)
+		for path != 0 && !pos.attacked(path.lsb(), them) {
+			path.pop()
+		}
+		if path == 0 {
+			moves = append(moves, move)
+		}
+	}
+	return moves
+}
+
+// a pseudo-legal move is legal if it doesn't leave the own king attacked
+func (pos *Position) legal(move Move) bool {
+	pos.make(move)
+	legal := !pos.left_in_check()
+	pos.unmake(move)
+	return legal
+}
+
+// true right after make if the move left the mover's king attacked
+func (pos *Position) left_in_check() bool {
+	return pos.attacked(pos.king_square(pos.turn.Other()), pos.turn)
+}
+
+// stops at the first legal move
+func (pos *Position) has_legal_move() bool {
+	var buffer [MAX_POSITION_MOVES]Move
+	for _, move := range pos.generate(buffer[:0], true, true) {
+		if pos.legal(move) {
+			return true
+		}
+	}
+	return false
+}
+
+// The pseudo-legal move with the from, to and promotion of a packed move,
+// flags included. NO_MOVE if the position has no such move, packed moves
+// come from the table or other positions and may not fit this one.
+func (pos *Position) pseudo_legal(code uint16) Move {
+	if code == 0 {
+		return NO_MOVE
+	}
+	move := Move(code)
+	from, to, promo := move.from(), move.to(), move.promo()
+	us := pos.turn
+	piece := pos.squares[from]
+	if piece == chess.NoPiece || piece.Color() != us {
+		return NO_MOVE
+	}
+	if piece.Type() == chess.King && pos.squares[to] == color_piece(chess.Rook, us) {
+		var castles [2]Move
+		for _, castle := range pos.generate_castles(castles[:0]) {
+			if castle.to() == to && promo == chess.NoPieceType {
+				return castle
+			}
+		}
+		return NO_MOVE
+	}
+	if pos.colors[us]&square_bb(to) != 0 {
+		return NO_MOVE
+	}
+	flags := Move(0)
+	if pos.squares[to] != chess.NoPiece {
+		flags = CAPTURE_FLAG
+	}
+	occupied := pos.occupied()
+
+	if piece.Type() != chess.Pawn {
+		if promo != chess.NoPieceType {
+			return NO_MOVE
+		}
+		if piece_attacks(piece.Type(), us, from, occupied)&square_bb(to) == 0 {
+			return NO_MOVE
+		}
+		return new_move(from, to, chess.NoPieceType, flags)
+	}
+
+	up, start, last := 8, RANK_2, RANK_8
+	if us == chess.Black {
+		up, start, last = -8, RANK_7, RANK_1
+	}
+	if (last&square_bb(to) != 0) != (promo != chess.NoPieceType) || promo == chess.King || promo > chess.Knight {
+		return NO_MOVE
+	}
+	one := chess.Square(int(from) + up)
+	switch {
+	case pawn_attacks[side_index(us)][from]&square_bb(to) != 0:
+		if to == pos.ep && flags == 0 {
+			return new_move(from, to, promo, CAPTURE_FLAG|EN_PASSANT_FLAG)
+		}
+		if flags == 0 {
+			return NO_MOVE
+		}
+	case flags != 0:
+		return NO_MOVE
+	case to == one:
+	case int(to) == int(one)+up && start&square_bb(from) != 0 && occupied&square_bb(one) == 0:
+		flags = DOUBLE_PUSH_FLAG
+	default:
+		return NO_MOVE
+	}
+	return new_move(from, to, promo, flags)
+}
+
+// appends the legal moves to the buffer
+func (pos *Position) legal_moves(moves []Move) []Move {
+	start := len(moves)
+	moves = pos.generate(moves, true, true)
+	legal := moves[:start]
+	for _, move := range moves[start:] {
+		if pos.legal(move) {
+			legal = append(legal, move)
+		}
+	}
+	return legal
+}
+
+// true if the move attacks the enemy king, directly or by uncovering a slider
+func (pos *Position) gives_check(move Move) bool {
+	us, them := pos.turn, pos.turn.Other()
+	from, to := move.from(), move.to()
+	king := pos.king_square(them)
+	occupied := pos.occupied()&^square_bb(from) | square_bb(to)
+	piece := pos.squares[from].Type()
+	if move.promo() != chess.NoPieceType {
+		piece = move.promo()
+	}
+	moved := square_bb(from)
+
+	if move.has(EN_PASSANT_FLAG) {
+		occupied &^= square_bb(en_passant_victim(to, us))
+	}
+	if move.has(CASTLE_FLAG) {
+		king_to, rook_from, rook_to := castle_squares(move)
+		occupied = pos.occupied()&^square_bb(from)&^square_bb(rook_from) | square_bb(king_to) | square_bb(rook_to)
+		moved |= square_bb(rook_from)
+		if rook_attacks(rook_to, occupied)&square_bb(king) != 0 {
+			return true
+		}
+	} else if piece_attacks(piece, us, to, occupied)&square_bb(king) != 0 {
+		return true
+	}
+
+	diagonal := (pos.bitboard(chess.Bishop, us) | pos.bitboard(chess.Queen, us)) &^ moved
+	straight := (pos.bitboard(chess.Rook, us) | pos.bitboard(chess.Queen, us)) &^ moved
+	return bishop_attacks(king, occupied)&diagonal != 0 || rook_attacks(king, occupied)&straight != 0
+}
@@ -2,19 +2,20 @@ package main
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/notnil/chess"
 )
 
-func print_root_move_1(root bool, game *chess.Game, move *chess.Move, tempeval int, cap int, history [mem_size]string) {
+func print_root_move_1(root bool, pos *Position, move Move, tempeval int, cap int, line []Move) {
 	if VERBOSE_FLAG < 2 || !root {
 		return
 	}
 	fmt.Println("\nNew best root move:", move)
 	fmt.Println("Evaluation:", tempeval, "Prev eval (forced beta/alpha):", cap)
-	fmt.Println("Move path:", history)
-	// fmt.Println(game.Position().Board().Draw())
+	fmt.Println("Move path:", line)
+	// fmt.Println(pos.fen())
 }
 
 func print_root_move_2(root bool) {
@@ -29,9 +30,6 @@ func print_minmax_root_end(root bool) {
 		return
 	}
 	fmt.Print("\n")
-	if check_time_up() {
-		fmt.Println("\n -- Returned early because time was up... -- ")
-	}
 }
 
 func print_iter_1(delay time.Time) {
@@ -40,19 +38,52 @@ func print_iter_1(delay time.Time) {
 	}
 	fmt.Println("\n -- Searching deeper --")
 	fmt.Println("Depth:", DEPTH)
-	fmt.Println("Time left:", delay.Sub(time.Now()), "\n")
+	fmt.Print("Time left: ", delay.Sub(time.Now()), "\n\n")
 }
 
-func print_iter_11(output *chess.Move, eval int, history [mem_size]string) {
+func print_iter_11(output Move, eval int, line []Move) {
 	if VERBOSE_FLAG < 1 {
 		return
 	}
 	fmt.Println("\n", output)
-	fmt.Println("line:", history)
+	fmt.Println("line:", line)
 	fmt.Println("evaluation:", eval)
 	fmt.Println("depth:", DEPTH)
 }
 
+// search report for the gui after each completed iteration
+func print_iter_info(eval int, line []Move, max bool) {
+	print_iter_bound(eval, line, max, "")
+}
+
+// bound is "lowerbound" or "upperbound" when an aspiration window failed,
+// line has to be legal from the root and is left out of the info if empty
+func print_iter_bound(eval int, line []Move, max bool, bound string) {
+	if protocol == NoProtocol || (protocol == XboardProtocol && (!xboard_post || bound != "")) {
+		return
+	}
+	eval = relative_eval(eval, max)
+	elapsed := time.Since(search_start)
+	pv := strings.Join(pv_strings(line), " ")
+	if protocol == XboardProtocol {
+		// ply score time(centiseconds) nodes pv
+		fmt.Printf("%d %d %d %d %s\n", DEPTH, xboard_score(eval), elapsed.Milliseconds()/10, explored, pv)
+		return
+	}
+	nps := 0
+	if elapsed > 0 {
+		nps = int(float64(explored) / elapsed.Seconds())
+	}
+	score := uci_score(eval)
+	if bound != "" {
+		score += " " + bound
+	}
+	if pv != "" {
+		pv = " pv " + pv
+	}
+	fmt.Printf("info depth %d score %s nodes %d nps %d time %d%s\n", DEPTH, score, explored, nps, elapsed.Milliseconds(), pv)
+}
+
 func print_iter_2() {
 	if VERBOSE_FLAG < 1 {
 		return
@@ -62,7 +93,7 @@ func print_iter_2() {
 	fmt.Println("# nodes at depth", explored_depth)
 	fmt.Println("Total hashes used", hash_count)
 	fmt.Println("Hashes written", hash_write_count)
-	fmt.Println("Hash types (edge, alpha, beta)", hash_count_list)
+	fmt.Println("Hash cutoffs (exact, lower, upper)", hash_count_list)
 }
 
 func print_turn_complete(game *chess.Game, move *chess.Move, start time.Time) {
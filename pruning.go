@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math"
+
+	"github.com/notnil/chess"
+)
+
+// reduction by depth and move number, grows with the log of both
+var lmr_table = build_lmr_table()
+
+func build_lmr_table() (table [64][64]int) {
+	for depth := 1; depth < 64; depth++ {
+		for number := 1; number < 64; number++ {
+			table[depth][number] = int(0.75 + math.Log(float64(depth))*math.Log(float64(number))/2.25)
+		}
+	}
+	return
+}
+
+// Late move reductions: moves late in the ordered list rarely turn out best,
+// so quiet ones get searched shallower first. Captures, promotions, checks
+// and moves out of check are never reduced.
+func late_move_reduction(pos *Position, move Move, check bool, depth int, number int) int {
+	if !DO_LMR || depth < LMR_MIN_DEPTH || number < LMR_MIN_MOVES {
+		return 0
+	}
+	if !is_quiet(move) || check || pos.in_check() {
+		return 0
+	}
+	reduction := lmr_table[Min(depth, 63)][Min(number, 63)]
+	// always leave at least one ply before quiescence
+	if reduction > depth-2 {
+		reduction = depth - 2
+	}
+	return reduction
+}
+
+// Null move pruning: pass the turn and search with a null window at reduced
+// depth. If the opponent still can't get below beta with a free move, the real
+// moves will fail high too. Skipped in check, right after another null move
+// and when only pawns are left, since zugzwang breaks the assumption.
+func (t *Thread) null_move_pruning(pos *Position, hash uint64, depth int, beta int, preval int) (cutoff bool, ignore bool) {
+	if !DO_NULL_MOVE || t.null_move_disabled || depth < NULL_MOVE_MIN_DEPTH || t.ply == 0 {
+		return false, false
+	}
+	max := pos.turn == chess.White
+	if beta >= MATE_BOUND || relative_eval(preval, max) < beta {
+		return false, false
+	}
+	if after_null_move(pos) || pos.in_check() || !pos.has_pieces(pos.turn) {
+		return false, false
+	}
+
+	reduction := NULL_MOVE_R
+	if depth > 6 {
+		reduction++
+	}
+	// repetitions can't reach across the null move
+	barrier := t.barrier
+	t.barrier = len(t.path)
+	pos.make_null()
+	eval, ignore := t.search_child(pos, depth-1-reduction, -beta, -beta+1, preval)
+	pos.unmake_null()
+	t.barrier = barrier
+	if ignore {
+		return false, true
+	}
+	if eval < beta {
+		return false, false
+	}
+	if !DO_NULL_VERIFY || depth < NULL_VERIFY_DEPTH {
+		return true, false
+	}
+
+	// verification: search the node itself at reduced depth without null moves
+	t.null_move_disabled = true
+	_, eval, ignore = t.minimax_hashing_core(pos, hash, depth-reduction, beta-1, beta, preval, 0, false)
+	t.null_move_disabled = false
+	if ignore {
+		return false, true
+	}
+	return eval >= beta, false
+}
+
+// null moves go into the history as NO_MOVE
+func after_null_move(pos *Position) bool {
+	return len(pos.history) > 0 && pos.last_move() == NO_MOVE
+}
+
+// Reverse futility pruning (static null move): close to the horizon a static
+// score this far above beta won't come down to it again.
+func reverse_futility(pos *Position, depth int, beta int, preval int) bool {
+	if !DO_REVERSE_FUTILITY || depth > REVERSE_FUTILITY_DEPTH || beta >= 10000 || beta <= -10000 || pos.in_check() {
+		return false
+	}
+	max := pos.turn == chess.White
+	return relative_eval(preval, max)-REVERSE_FUTILITY_MARGIN*depth >= beta
+}
+
+// Razoring: when the static score is hopelessly below alpha close to the
+// horizon, only captures can save the node. Quiescence decides, and its score
+// is used if it confirms the fail low.
+func (t *Thread) razoring(pos *Position, depth int, alpha int, beta int, preval int) (cutoff bool, eval int, ignore bool) {
+	if !DO_RAZORING || depth > RAZOR_DEPTH || alpha >= 10000 || alpha <= -10000 || pos.in_check() {
+		return false, 0, false
+	}
+	max := pos.turn == chess.White
+	if relative_eval(preval, max)+RAZOR_MARGIN*depth >= alpha {
+		return false, 0, false
+	}
+	_, eval, ignore = t.minimax_hashing(pos, 0, alpha, beta, preval)
+	return !ignore && eval < alpha, eval, ignore
+}
+
+// Futility pruning: at frontier nodes a quiet move can't gain more than the
+// margin, so with the static score that far below alpha it isn't searched.
+// Needs a searched move to fall back on.
+func futility_prune(pos *Position, move Move, check bool, depth int, alpha int, preval int, searched bool) bool {
+	if !DO_FUTILITY || !searched || depth > FUTILITY_DEPTH || alpha >= 10000 || alpha <= -10000 {
+		return false
+	}
+	if !is_quiet(move) || check || pos.in_check() {
+		return false
+	}
+	max := pos.turn == chess.White
+	return relative_eval(preval, max)+FUTILITY_MARGIN*depth <= alpha
+}
+
+// Delta pruning: a capture in quiescence that can't bring the stand pat score
+// back to alpha even when winning the piece outright is skipped.
+func delta_prune(pos *Position, move Move, stand int, alpha int) bool {
+	if !DO_DELTA || alpha >= 10000 || alpha <= -10000 || move.promo() != chess.NoPieceType || !move.has(CAPTURE_FLAG) {
+		return false
+	}
+	victim := see_value(pos.squares[move.to()].Type())
+	if move.has(EN_PASSANT_FLAG) {
+		victim = see_value(chess.Pawn)
+	}
+	return stand+victim+DELTA_MARGIN < alpha
+}
@@ -0,0 +1,95 @@
+package main
+
+/*
+
+Principal variation.
+
+Triangular table: row ply holds the best line found from the node at that
+ply. A node copies its child's row behind the move whenever it finds a new
+best move, so row 0 ends up with the line from the root. A node cut off by the
+table has no searched child, its line is followed through the table instead.
+The line of the last completed iteration is searched first in the next one.
+
+*/
+
+func (t *Thread) clear_pv(ply int) {
+	t.pv_length[ply] = 0
+}
+
+func (t *Thread) update_pv(ply int, move Move) {
+	t.pv[ply][0] = move
+	t.pv_length[ply] = 1
+	if ply+1 < mem_size {
+		t.pv_length[ply] += copy(t.pv[ply][1:], t.pv[ply+1][:t.pv_length[ply+1]])
+	}
+}
+
+// line of a node cut off by the table: the move, then the table's moves from
+// there on. Stops at a missing or illegal move and before a position repeats.
+func (t *Thread) hash_pv(pos *Position, ply int, move Move) {
+	line := t.pv[ply][:0]
+	var keys [mem_size]uint64
+	for ply+len(line) < mem_size && move != NO_MOVE && pos.legal(move) {
+		keys[len(line)] = pos.key
+		line = append(line, move)
+		pos.make(move)
+		entry, found := probe_hash(pos.key)
+		move = NO_MOVE
+		if found {
+			move = pos.pseudo_legal(entry.move)
+		}
+		for _, key := range keys[:len(line)] {
+			if key == pos.key {
+				move = NO_MOVE
+			}
+		}
+	}
+	for i := len(line) - 1; i >= 0; i-- {
+		pos.unmake(line[i])
+	}
+	t.pv_length[ply] = len(line)
+}
+
+// copy of the line from the root, the table is overwritten by the next search
+func (t *Thread) root_pv() []Move {
+	return append([]Move(nil), t.pv[0][:t.pv_length[0]]...)
+}
+
+// move of the previous iteration's line, if the path to this node followed it
+func (t *Thread) pv_move(pos *Position, ply int) Move {
+	if ply >= len(t.prev_pv) || len(pos.history) < ply {
+		return NO_MOVE
+	}
+	for i, entry := range pos.history[len(pos.history)-ply:] {
+		if !same_move(entry.move, t.prev_pv[i]) {
+			return NO_MOVE
+		}
+	}
+	return t.prev_pv[ply]
+}
+
+// the legal prefix of a line, moves from the table may belong to a colliding position
+func legal_pv(pos *Position, pv []Move) []Move {
+	var buffer [MAX_POSITION_MOVES]Move
+	var line []Move
+	for _, move := range pv {
+		move = decode_move(pos.legal_moves(buffer[:0]), encode_move(move))
+		if move == NO_MOVE {
+			break
+		}
+		line = append(line, move)
+		pos.make(move)
+	}
+	for i := len(line) - 1; i >= 0; i-- {
+		pos.unmake(line[i])
+	}
+	return line
+}
+
+func pv_strings(pv []Move) []string {
+	line := make([]string, len(pv))
+	for i, move := range pv {
+		line[i] = move.String()
+	}
+	return line
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/notnil/chess"
+)
+
+/*
+
+Static exchange evaluation.
+
+Plays out every capture on the target square, cheapest attacker first, and
+returns what the side making the move wins (negative when it loses material).
+Pieces are taken out of the occupancy as they capture, so sliders lined up
+behind them (x-rays) join the exchange. Either side may stop capturing when
+that's better for it.
+
+*/
+
+func see(pos *Position, move Move) int {
+	if move.has(CASTLE_FLAG) {
+		return 0 // nothing is captured, and the king can't be taken
+	}
+	from, target := move.from(), move.to()
+	side := pos.turn
+	occupied := pos.occupied() &^ square_bb(from)
+
+	var gain [32]int
+	gain[0] = see_value(pos.squares[target].Type())
+	if move.has(EN_PASSANT_FLAG) {
+		gain[0] = see_value(chess.Pawn)
+		occupied &^= square_bb(en_passant_victim(target, side))
+	}
+	on_square := see_value(pos.squares[from].Type())
+	if move.promo() != chess.NoPieceType {
+		gain[0] += see_value(move.promo()) - see_value(chess.Pawn)
+		on_square = see_value(move.promo())
+	}
+
+	d := 0
+	for d < len(gain)-1 {
+		side = side.Other()
+		// pieces that captured are gone from occupied, so sliders behind them attack now
+		attackers := pos.attackers_to(target, occupied) & occupied & pos.colors[side]
+		if attackers == 0 {
+			break
+		}
+		from = least_valuable(pos, attackers)
+		d++
+		gain[d] = on_square - gain[d-1]
+		on_square = see_value(pos.squares[from].Type())
+		occupied &^= square_bb(from)
+	}
+	// back up from the end, each side may stop instead of capturing
+	for ; d > 0; d-- {
+		gain[d-1] = -Max(-gain[d-1], gain[d])
+	}
+	return gain[0]
+}
+
+func see_value(p chess.PieceType) int {
+	if p == chess.NoPieceType {
+		return 0
+	}
+	return PieceValue(p)
+}
+
+// square of the cheapest piece of the set
+func least_valuable(pos *Position, set Bitboard) chess.Square {
+	for _, p := range []chess.PieceType{chess.Pawn, chess.Knight, chess.Bishop, chess.Rook, chess.Queen, chess.King} {
+		pieces := set & (pos.pieces[color_piece(p, chess.White)] | pos.pieces[color_piece(p, chess.Black)])
+		if pieces != 0 {
+			return pieces.lsb()
+		}
+	}
+	return chess.NoSquare
+}
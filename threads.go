@@ -0,0 +1,175 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/notnil/chess"
+)
+
+/*
+
+Lazy SMP.
+
+Every thread runs its own iterative deepening on its own Position and they
+only talk through the shared transposition table. Helpers start on
+alternating depths and skip a depth once half of the threads are on it, so
+they fill the table with slightly different trees for the main thread.
+
+Only the main thread (id 0) manages time, prints and decides when to stop.
+Its move is played unless a helper completed a deeper iteration.
+
+*/
+
+const MAX_THREADS int = 256
+
+var THREADS int = 1 // search threads, set with the Threads option
+
+type Thread struct {
+	id                 int
+	depth              int   // depth of the running iteration
+	ply                int   // distance of the current node from the root
+	explored           int64 // nodes, read by the main thread while searching
+	explored_depth     [mem_size]int
+	hash_count         int // table statistics, the prints show the main thread's
+	hash_write_count   int
+	hash_count_list    [3]int
+	null_move_disabled bool // set during a verification search
+	killer_moves       [mem_size][2]Move
+	history_table      [2][64][64]int
+	countermove_table  [2][64][64]Move
+	pos                *Position                          // the game replayed up to the root, searched with make and unmake
+	moves              [mem_size][MAX_POSITION_MOVES]Move // move list of each ply
+	path               []path_entry                       // positions of the game and the search path
+	root_index         int                                // where the root is on the path
+	root_fifty         int
+	root_side          chess.Color
+	barrier            int // repetitions aren't searched below this, set across null moves
+	pv                 [mem_size][mem_size]Move
+	pv_length          [mem_size]int
+	prev_pv            []Move // line of the last completed iteration
+	completed          int    // deepest finished iteration
+	best               Move
+	eval               int
+}
+
+var threads = []*Thread{{id: 0}}
+var helpers_stop int32 = 0 // set atomically once the main thread is done
+var depth_searchers [mem_size]int32
+
+// resizes the pool between searches, nothing carries over since every search
+// resets its threads
+func set_threads(n int) {
+	if n < 1 {
+		n = 1
+	} else if n > MAX_THREADS {
+		n = MAX_THREADS
+	}
+	THREADS = n
+	for len(threads) < n {
+		threads = append(threads, &Thread{id: len(threads)})
+	}
+	threads = threads[:n]
+}
+
+func main_thread() *Thread {
+	return threads[0]
+}
+
+func (t *Thread) reset(root *Position) {
+	atomic.StoreInt64(&t.explored, 0)
+	for i := range t.explored_depth {
+		t.explored_depth[i] = 0
+	}
+	t.hash_count, t.hash_write_count, t.hash_count_list = 0, 0, [3]int{}
+	t.ply = 0
+	t.null_move_disabled = false
+	t.completed = 0
+	t.prev_pv = nil
+	t.best = NO_MOVE
+	t.eval = 0
+	t.clear_heuristics()
+	t.setup_path(root)
+}
+
+func total_explored() int64 {
+	var total int64
+	for _, t := range threads {
+		total += atomic.LoadInt64(&t.explored)
+	}
+	return total
+}
+
+// the prints and limits read the package counters, the old searches (flags
+// 1-3) count into them directly
+func (t *Thread) publish_counts() {
+	if flag < 4 {
+		return
+	}
+	DEPTH = t.depth
+	explored = int(total_explored())
+	explored_depth = t.explored_depth
+	hash_count, hash_write_count, hash_count_list = t.hash_count, t.hash_write_count, t.hash_count_list
+}
+
+// runs the main thread's iterative deepening with all helpers searching alongside
+func search_threads(root *Position, max bool, tm *TimeManager) Move {
+	main := main_thread()
+	main.reset(root)
+	if len(threads) == 1 {
+		return main.iterative_deepening(max, tm)
+	}
+
+	atomic.StoreInt32(&helpers_stop, 0)
+	for i := range depth_searchers {
+		depth_searchers[i] = 0
+	}
+	var wg sync.WaitGroup
+	for _, helper := range threads[1:] {
+		helper.reset(root)
+		wg.Add(1)
+		go func(helper *Thread) {
+			defer wg.Done()
+			helper.helper_search(max)
+		}(helper)
+	}
+
+	output := main.iterative_deepening(max, tm)
+	atomic.StoreInt32(&helpers_stop, 1)
+	wg.Wait()
+	main.publish_counts()
+
+	for _, helper := range threads[1:] {
+		if helper.best != NO_MOVE && helper.completed > main.completed {
+			output = helper.best
+			main.completed = helper.completed
+		}
+	}
+	return output
+}
+
+func (t *Thread) helper_search(max bool) {
+	var eval int
+	t.depth = 1 + t.id%2
+	for t.depth <= depth_limit && t.depth < MAX_DEPTH {
+		if 2*atomic.LoadInt32(&depth_searchers[t.depth]) >= int32(len(threads)) {
+			t.depth++
+			continue
+		}
+
+		atomic.AddInt32(&depth_searchers[t.depth], 1)
+		best, value, pv := t.aspiration_search(t.pos, max, eval)
+		atomic.AddInt32(&depth_searchers[t.depth], -1)
+		if t.check_time_up() {
+			return
+		}
+		t.best, t.eval, t.completed = best, value, t.depth
+		t.prev_pv = legal_pv(t.pos, pv)
+		eval = value
+
+		if eval >= 10000 || eval <= -10000 {
+			return
+		}
+		t.depth++
+	}
+}
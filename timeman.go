@@ -0,0 +1,202 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+
+Time management.
+
+Every move gets a soft and a hard budget from the clock. Iterative deepening
+doesn't start a new depth once the soft budget (scaled by how unsettled the
+search is) is used up, and the running depth is aborted at the hard budget.
+A ponder search runs without a budget until ponderhit, which hands the running
+search the budget of the real move from then on.
+
+*/
+
+const DEFAULT_MOVES_TO_GO = 30               // assumed moves left when the gui doesn't send movestogo
+const MOVE_OVERHEAD = 50 * time.Millisecond  // lag between the engine and the gui clock
+const MIN_THINK_TIME = 10 * time.Millisecond // never budget less than this
+const HARD_TIME_RATIO = 4                    // hard budget is this many soft budgets at most
+const STABLE_ITERATIONS = 4                  // same best move for this many iterations is clearly best
+const MAX_BRANCHING = 8.0                    // cap on the predicted growth of the next iteration
+
+var soft_time = time.Duration(TIME_TO_THINK) * time.Second // target thinking time of a move
+var hard_time = time.Duration(TIME_TO_THINK) * time.Second // the running iteration is aborted after this
+var time_managed = false                                   // budgets come from game clocks and may flex
+
+var time_lock sync.Mutex       // ponderhit changes the budget while the search reads it
+var running_clock *TimeManager // time manager of the running search
+
+type TimeManager struct {
+	start       time.Time
+	soft        time.Duration
+	hard        time.Duration
+	managed     bool
+	best        string  // best move of the last iteration
+	stable      int     // iterations the best move hasn't changed
+	instability float64 // decaying count of best move changes
+	score       int     // side relative score of the last iteration
+	iteration   time.Duration
+	branching   float64 // time ratio between the last two iterations
+}
+
+// the time manager of a new search, it also sets the deadline
+func new_time_manager() *TimeManager {
+	time_lock.Lock()
+	defer time_lock.Unlock()
+	tm := &TimeManager{
+		start:     time.Now(),
+		soft:      soft_time,
+		hard:      hard_time,
+		managed:   time_managed,
+		branching: 2,
+	}
+	running_clock = tm
+	atomic.StoreInt64(&delay, tm.deadline().UnixNano())
+	return tm
+}
+
+// the point where the running iteration is aborted
+func (tm *TimeManager) deadline() time.Time {
+	return tm.start.Add(tm.hard)
+}
+
+func search_deadline() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&delay))
+}
+
+func past_deadline() bool {
+	return time.Now().UnixNano() > atomic.LoadInt64(&delay)
+}
+
+// the pondered move was played, the running search keeps its iterations and
+// goes on with the budget of limits counted from now
+func ponderhit(limits SearchLimits, max bool) {
+	time_lock.Lock()
+	defer time_lock.Unlock()
+	allocate_time(limits, max)
+	if tm := running_clock; tm != nil {
+		tm.start, tm.soft, tm.hard, tm.managed = time.Now(), soft_time, hard_time, time_managed
+		atomic.StoreInt64(&delay, tm.deadline().UnixNano())
+	}
+}
+
+// called after every completed iteration, returns false when there isn't
+// enough time worth spending on another one
+func (tm *TimeManager) keep_searching(best Move, score int, took time.Duration, only_move bool) bool {
+	time_lock.Lock()
+	defer time_lock.Unlock()
+	elapsed := time.Since(tm.start)
+	if tm.iteration > 0 && took > 0 {
+		tm.branching = float64(took) / float64(tm.iteration)
+		if tm.branching > MAX_BRANCHING {
+			tm.branching = MAX_BRANCHING
+		}
+	}
+	tm.iteration = took
+
+	changed := best != NO_MOVE && best.String() != tm.best && tm.best != ""
+	drop := tm.score - score
+	first := tm.best == ""
+	tm.instability *= 0.5
+	if changed {
+		tm.instability += 1
+		tm.stable = 0
+	} else {
+		tm.stable++
+	}
+	if best != NO_MOVE {
+		tm.best = best.String()
+	}
+	tm.score = score
+
+	// the next iteration can't finish before the hard limit
+	if elapsed+time.Duration(float64(took)*tm.branching) > tm.hard {
+		return false
+	}
+	if !tm.managed {
+		return elapsed < tm.soft
+	}
+	if only_move {
+		return false
+	}
+
+	target := float64(tm.soft) * (1 + tm.instability)
+	if !first && drop > 100 {
+		target *= 1.6
+	} else if !first && drop > 30 {
+		target *= 1.3
+	}
+	if tm.stable >= STABLE_ITERATIONS {
+		target *= 0.6
+	}
+	if target > float64(tm.hard) {
+		target = float64(tm.hard)
+	}
+	return elapsed < time.Duration(target)
+}
+
+// sets the package time budget for the next search from a go command
+func allocate_time(limits SearchLimits, max bool) {
+	soft_time, hard_time = allocate_budget(limits, max)
+	time_managed = limits.movetime == 0 && !limits.infinite && !limits.ponder && (limits.wtime > 0 || limits.btime > 0)
+}
+
+func allocate_budget(limits SearchLimits, max bool) (soft time.Duration, hard time.Duration) {
+	if limits.infinite || limits.ponder {
+		return time.Hour * 24, time.Hour * 24
+	}
+	if limits.movetime > 0 {
+		t := limits.movetime - MOVE_OVERHEAD
+		if t < MIN_THINK_TIME {
+			t = MIN_THINK_TIME
+		}
+		return t, t
+	}
+	remaining, inc := limits.wtime, limits.winc
+	if !max {
+		remaining, inc = limits.btime, limits.binc
+	}
+	if remaining <= 0 {
+		if limits.depth > 0 || limits.nodes > 0 {
+			return time.Hour * 24, time.Hour * 24
+		}
+		t := time.Duration(TIME_TO_THINK) * time.Second
+		return t, t
+	}
+
+	remaining -= MOVE_OVERHEAD
+	if remaining < MIN_THINK_TIME {
+		return MIN_THINK_TIME, MIN_THINK_TIME
+	}
+	moves_to_go := limits.movestogo
+	if moves_to_go <= 0 {
+		moves_to_go = DEFAULT_MOVES_TO_GO
+	}
+
+	soft = remaining/time.Duration(moves_to_go) + inc*3/4
+	hard = soft * HARD_TIME_RATIO
+	// never risk more than a fraction of the clock on a single move
+	max_hard := remaining / 3
+	if moves_to_go == 1 {
+		max_hard = remaining * 4 / 5
+	}
+	if hard > max_hard {
+		hard = max_hard
+	}
+	if soft > hard {
+		soft = hard
+	}
+	if soft < MIN_THINK_TIME {
+		soft = MIN_THINK_TIME
+	}
+	if hard < soft {
+		hard = soft
+	}
+	return soft, hard
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+/*
+
+Universal Chess Interface frontend.
+
+Run the binary with the "uci" argument and it reads commands from stdin
+and answers on stdout, so any UCI GUI (Cute Chess, Arena, ...) can drive it.
+
+Supported commands:
+	uci, isready, setoption, ucinewgame, position, go, stop, ponderhit, quit
+	key (not UCI: prints the Zobrist key of the current position in hex)
+
+Options:
+	Threads (Lazy SMP search threads)
+	Contempt (centipawns a draw is worth less than equal, negative seeks draws)
+	Hash (transposition table size in MB)
+	PolyglotKeys (Zobrist keys from the Polyglot table instead of random ones)
+	UCI_Chess960 (castles are sent and read as the king taking its rook)
+
+*/
+
+const ENGINE_NAME = "chess-engine-golang"
+const ENGINE_AUTHOR = "0hq"
+
+// Protocol is the frontend the engine is talking to.
+type Protocol int8
+
+const (
+	// Console output only (engine vs stockfish)
+	NoProtocol Protocol = iota
+	// Universal Chess Interface
+	UCIProtocol
+	// Chess Engine Communication Protocol (xboard)
+	XboardProtocol
+)
+
+var protocol = NoProtocol
+
+// parameters of a "go" command
+type SearchLimits struct {
+	wtime     time.Duration
+	btime     time.Duration
+	winc      time.Duration
+	binc      time.Duration
+	movestogo int
+	depth     int
+	nodes     int
+	movetime  time.Duration
+	infinite  bool
+	ponder    bool
+}
+
+type uci_state struct {
+	root      *Position   // the game replayed up to the position to search
+	game      *chess.Game // the same game for the opening book, nil in Chess960
+	position  []string    // arguments of the last position command
+	limits    SearchLimits
+	searching bool
+	cancel    context.CancelFunc
+	done      chan struct{} // closed once the running search has finished
+	release   chan struct{} // stop or ponderhit, an infinite or ponder search may report
+}
+
+func uci_loop() {
+	protocol = UCIProtocol
+	VERBOSE_FLAG = 0
+	setup_uci()
+
+	state := &uci_state{}
+	uci_position(state, []string{"startpos"})
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "uci":
+			fmt.Println("id name", ENGINE_NAME)
+			fmt.Println("id author", ENGINE_AUTHOR)
+			fmt.Printf("option name Threads type spin default 1 min 1 max %d\n", MAX_THREADS)
+			fmt.Println("option name Contempt type spin default 0 min -1000 max 1000")
+			fmt.Printf("option name Hash type spin default %d min 1 max %d\n", DEFAULT_HASH_MB, MAX_HASH_MB)
+			fmt.Println("option name PolyglotKeys type check default false")
+			fmt.Println("option name UCI_Chess960 type check default false")
+			fmt.Println("uciok")
+		case "isready":
+			fmt.Println("readyok")
+		case "setoption":
+			uci_wait(state)
+			uci_setoption(state, fields[1:])
+		case "ucinewgame":
+			uci_wait(state)
+			setup_uci()
+			uci_position(state, []string{"startpos"})
+		case "position":
+			uci_wait(state)
+			uci_position(state, fields[1:])
+		case "go":
+			uci_wait(state)
+			uci_go(state, fields[1:])
+		case "key":
+			uci_wait(state)
+			fmt.Printf("info string key %016x\n", state.root.key)
+		case "stop":
+			uci_stop(state)
+		case "ponderhit":
+			uci_ponderhit(state)
+		case "quit":
+			uci_stop(state)
+			return
+		}
+	}
+	uci_stop(state)
+}
+
+// resets everything that shouldn't carry over between games
+func setup_uci() {
+	opening_moves = true
+	init_explored_depth()
+	init_hash_count()
+	generateZobristConstants()
+	clear_hash()
+}
+
+// setoption name <id> [value <x>]
+func uci_setoption(state *uci_state, args []string) {
+	name, value := "", ""
+	for i := 0; i < len(args); i++ {
+		if args[i] == "name" && i+1 < len(args) {
+			name = args[i+1]
+			i++
+		} else if args[i] == "value" && i+1 < len(args) {
+			value = args[i+1]
+			i++
+		}
+	}
+	switch strings.ToLower(name) {
+	case "threads":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			fmt.Println("info string invalid thread count", value)
+			return
+		}
+		set_threads(n)
+	case "hash":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			fmt.Println("info string invalid hash size", value)
+			return
+		}
+		resize_hash(n)
+	case "contempt":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			fmt.Println("info string invalid contempt", value)
+			return
+		}
+		CONTEMPT = Max(-1000, Min(1000, n))
+	case "polyglotkeys":
+		on, err := strconv.ParseBool(value)
+		if err != nil {
+			fmt.Println("info string invalid polyglot keys", value)
+			return
+		}
+		// stored entries and game keys belong to the old constants, the
+		// position is replayed to key it and its path with the new ones
+		POLYGLOT_KEYS = on
+		generateZobristConstants()
+		clear_hash()
+		uci_position(state, state.position)
+	case "uci_chess960":
+		on, err := strconv.ParseBool(value)
+		if err != nil {
+			fmt.Println("info string invalid chess960", value)
+			return
+		}
+		// the position was read for the other mode, its moves are written
+		// out again with the new castle notation and replayed
+		CHESS960 = on
+		uci_position(state, uci_position_args(state))
+	default:
+		fmt.Println("info string unknown option", name)
+	}
+}
+
+func new_uci_game(fen func(*chess.Game)) *chess.Game {
+	if fen == nil {
+		fen, _ = chess.FEN(start_pos)
+	}
+	return chess.NewGame(fen, chess.UseNotation(chess.UCINotation{}))
+}
+
+// position [fen <fenstring> | startpos ] moves <move1> .... <movei>
+func uci_position(state *uci_state, args []string) {
+	if len(args) == 0 {
+		return
+	}
+	fen := start_pos
+	i := 1
+	if args[0] == "fen" {
+		for i < len(args) && args[i] != "moves" {
+			i++
+		}
+		fen = strings.Join(args[1:i], " ")
+	}
+	root, err := new_position(fen)
+	if err != nil {
+		fmt.Println("info string", err)
+		return
+	}
+	// the library can't castle in Chess960, there the game is only kept natively
+	var game *chess.Game
+	if !CHESS960 {
+		f, err := chess.FEN(fen)
+		if err != nil {
+			fmt.Println("info string invalid fen", err)
+			return
+		}
+		game = new_uci_game(f)
+	}
+	if i < len(args) && args[i] == "moves" {
+		for _, s := range args[i+1:] {
+			move := root.parse_move(s)
+			if move == NO_MOVE || (game != nil && game.MoveStr(s) != nil) {
+				fmt.Println("info string illegal move", s)
+				break
+			}
+			root.make(move)
+		}
+	}
+	state.root, state.game, state.position = root, game, args
+}
+
+// the last position command with the moves of the loaded position, castles
+// written the way the current mode reads them
+func uci_position_args(state *uci_state) []string {
+	var args []string
+	for _, arg := range state.position {
+		if arg == "moves" {
+			break
+		}
+		args = append(args, arg)
+	}
+	if len(state.root.history) > 0 {
+		args = append(args, "moves")
+		for _, entry := range state.root.history {
+			args = append(args, entry.move.String())
+		}
+	}
+	return args
+}
+
+func uci_go(state *uci_state, args []string) {
+	limits := SearchLimits{}
+	for i := 0; i < len(args); i++ {
+		value := 0
+		if i+1 < len(args) {
+			value, _ = strconv.Atoi(args[i+1])
+		}
+		switch args[i] {
+		case "wtime":
+			limits.wtime = time.Duration(value) * time.Millisecond
+		case "btime":
+			limits.btime = time.Duration(value) * time.Millisecond
+		case "winc":
+			limits.winc = time.Duration(value) * time.Millisecond
+		case "binc":
+			limits.binc = time.Duration(value) * time.Millisecond
+		case "movestogo":
+			limits.movestogo = value
+		case "depth":
+			limits.depth = value
+		case "nodes":
+			limits.nodes = value
+		case "movetime":
+			limits.movetime = time.Duration(value) * time.Millisecond
+		case "infinite":
+			limits.infinite = true
+			continue
+		case "ponder":
+			limits.ponder = true
+			continue
+		default:
+			continue
+		}
+		i++
+	}
+	state.limits = limits
+	uci_start(state, limits)
+}
+
+// launches the search in the background, bestmove is printed when it returns
+func uci_start(state *uci_state, limits SearchLimits) {
+	root := state.root.copy()
+	var game *chess.Game
+	if state.game != nil {
+		game = state.game.Clone()
+	}
+	max := root.turn == chess.White
+	apply_limits(limits, max)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	release := make(chan struct{}, 1)
+	state.cancel = cancel
+	state.done = done
+	state.release = release
+	state.searching = true
+
+	result := engine_async(ctx, root, game, max)
+	go func() {
+		defer close(done)
+		move := <-result
+		// infinite and ponder searches may only report once the gui allows it
+		if limits.infinite || limits.ponder {
+			<-release
+		}
+		print_bestmove(move)
+	}()
+}
+
+// sets the package search limits used by engine() from a go command
+func apply_limits(limits SearchLimits, max bool) {
+	allocate_time(limits, max)
+	depth_limit = MAX_ITERATIVE_DEPTH
+	if limits.depth > 0 {
+		depth_limit = Min(limits.depth, MAX_DEPTH)
+	}
+	node_limit = limits.nodes
+	// searched until stopped, as deep as the thread tables go
+	if limits.infinite || limits.ponder {
+		depth_limit = MAX_DEPTH
+	}
+}
+
+// blocks until the running search has reported its bestmove
+func uci_wait(state *uci_state) {
+	if !state.searching {
+		return
+	}
+	<-state.done
+	state.searching = false
+}
+
+func uci_stop(state *uci_state) {
+	if !state.searching {
+		return
+	}
+	state.cancel()
+	state.release <- struct{}{}
+	uci_wait(state)
+}
+
+// the opponent played the expected move, keep thinking on our own clock
+func uci_ponderhit(state *uci_state) {
+	if !state.searching || !state.limits.ponder {
+		return
+	}
+	limits := state.limits
+	limits.ponder = false
+	state.limits = limits
+	ponderhit(limits, state.root.turn == chess.White)
+	if !limits.infinite {
+		state.release <- struct{}{}
+	}
+}
+
+func print_bestmove(move Move) {
+	fmt.Println("bestmove", move.String())
+}
+
+func find_uci_move(pos *chess.Position, s string) *chess.Move {
+	for _, move := range pos.ValidMoves() {
+		if move.String() == s {
+			return move
+		}
+	}
+	return nil
+}
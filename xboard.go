@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+/*
+
+Chess Engine Communication Protocol (xboard / WinBoard) frontend.
+
+Run the binary with the "xboard" argument. Protocol version 2 with the
+usermove and setboard features.
+
+Supported commands:
+	xboard, protover, new, force, go, usermove, level, st, sd, time, otim,
+	post, nopost, undo, remove, result, setboard, ping, cores, memory, ?, quit
+
+*/
+
+var xboard_post = false // print thinking output
+
+type xboard_state struct {
+	game         *chess.Game
+	force        bool
+	engine_color chess.Color
+	limits       SearchLimits // level, st and sd settings
+	moves_per_tc int          // moves per time control, 0 for sudden death
+	engine_time  time.Duration
+	other_time   time.Duration
+	searching    bool
+	cancel       context.CancelFunc
+	result       <-chan Move
+}
+
+func xboard_loop() {
+	protocol = XboardProtocol
+	VERBOSE_FLAG = 0
+	setup_uci()
+
+	state := &xboard_state{}
+	xboard_new(state)
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	for {
+		select {
+		case move := <-state.result:
+			state.searching = false
+			xboard_play(state, to_chess_move(state.game, move))
+		case line, ok := <-lines:
+			if !ok {
+				xboard_stop(state)
+				return
+			}
+			if !xboard_command(state, strings.Fields(line)) {
+				return
+			}
+		}
+	}
+}
+
+// returns false on quit
+func xboard_command(state *xboard_state, fields []string) bool {
+	if len(fields) == 0 {
+		return true
+	}
+	args := fields[1:]
+	switch fields[0] {
+	case "xboard", "accepted", "rejected", "random", "hard", "easy", "computer":
+	case "protover":
+		fmt.Printf("feature myname=\"%s\" usermove=1 setboard=1 ping=1 smp=1 memory=1 colors=0 sigint=0 sigterm=0 done=1\n", ENGINE_NAME)
+	case "new":
+		xboard_stop(state)
+		xboard_new(state)
+	case "force", "result":
+		xboard_stop(state)
+		state.force = true
+	case "go":
+		xboard_stop(state)
+		state.force = false
+		state.engine_color = state.game.Position().Turn()
+		xboard_think(state)
+	case "usermove":
+		xboard_stop(state)
+		if len(args) == 0 {
+			return true
+		}
+		if err := state.game.MoveStr(args[0]); err != nil {
+			fmt.Println("Illegal move:", args[0])
+			return true
+		}
+		if !state.force && state.game.Position().Turn() == state.engine_color {
+			xboard_think(state)
+		}
+	case "?":
+		if state.searching {
+			state.cancel()
+		}
+	case "level":
+		if len(args) < 3 {
+			return true
+		}
+		state.moves_per_tc, _ = strconv.Atoi(args[0])
+		base := xboard_parse_base(args[1])
+		inc, _ := strconv.ParseFloat(args[2], 64)
+		increment := time.Duration(inc * float64(time.Second))
+		state.engine_time, state.other_time = base, base
+		state.limits.winc, state.limits.binc = increment, increment
+		state.limits.movetime = 0
+	case "st":
+		if len(args) > 0 {
+			seconds, _ := strconv.ParseFloat(args[0], 64)
+			state.limits.movetime = time.Duration(seconds * float64(time.Second))
+		}
+	case "sd":
+		if len(args) > 0 {
+			state.limits.depth, _ = strconv.Atoi(args[0])
+		}
+	case "time", "otim":
+		if len(args) == 0 {
+			return true
+		}
+		centiseconds, _ := strconv.Atoi(args[0])
+		clock := time.Duration(centiseconds) * 10 * time.Millisecond
+		if fields[0] == "time" {
+			state.engine_time = clock
+		} else {
+			state.other_time = clock
+		}
+	case "post":
+		xboard_post = true
+	case "nopost":
+		xboard_post = false
+	case "undo":
+		xboard_stop(state)
+		xboard_undo(state, 1)
+	case "remove":
+		xboard_stop(state)
+		xboard_undo(state, 2)
+	case "setboard":
+		xboard_stop(state)
+		fen, err := chess.FEN(strings.Join(args, " "))
+		if err != nil {
+			fmt.Println("tellusererror Illegal position")
+			return true
+		}
+		state.game = new_uci_game(fen)
+	case "cores":
+		if len(args) > 0 {
+			n, _ := strconv.Atoi(args[0])
+			xboard_reconfigure(state, func() { set_threads(n) })
+		}
+	case "memory":
+		if len(args) > 0 {
+			n, _ := strconv.Atoi(args[0])
+			xboard_reconfigure(state, func() { resize_hash(n) })
+		}
+	case "ping":
+		if len(args) > 0 {
+			fmt.Println("pong", args[0])
+		}
+	case "quit":
+		xboard_stop(state)
+		return false
+	default:
+		// anything else that parses as a move is a move from protocol version 1
+		if find_uci_move(state.game.Position(), fields[0]) != nil {
+			return xboard_command(state, []string{"usermove", fields[0]})
+		}
+		fmt.Println("Error (unknown command):", fields[0])
+	}
+	return true
+}
+
+func xboard_new(state *xboard_state) {
+	setup_uci()
+	state.game = new_uci_game(nil)
+	state.force = false
+	state.engine_color = chess.Black
+	state.limits = SearchLimits{}
+	state.moves_per_tc = 0
+	state.engine_time, state.other_time = 0, 0
+}
+
+// starts thinking about the engine's move in the background
+func xboard_think(state *xboard_state) {
+	game := state.game.Clone()
+	max := game.Position().Turn() == chess.White
+	limits := state.limits
+	limits.wtime, limits.btime = state.engine_time, state.other_time
+	if !max {
+		limits.wtime, limits.btime = state.other_time, state.engine_time
+	}
+	if state.moves_per_tc > 0 {
+		played := len(game.Moves()) / 2
+		limits.movestogo = state.moves_per_tc - played%state.moves_per_tc
+	}
+	apply_limits(limits, max)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	state.cancel = cancel
+	state.result = engine_async(ctx, position_from_game(game), game, max)
+	state.searching = true
+}
+
+// the threads and table can't change under a running search, it is stopped
+// and thinks again with the new ones so the engine still moves
+func xboard_reconfigure(state *xboard_state, apply func()) {
+	searching := state.searching
+	xboard_stop(state)
+	apply()
+	if searching {
+		xboard_think(state)
+	}
+}
+
+// aborts the current search and drops its move
+func xboard_stop(state *xboard_state) {
+	if !state.searching {
+		return
+	}
+	state.cancel()
+	<-state.result
+	state.searching = false
+}
+
+func xboard_play(state *xboard_state, move *chess.Move) {
+	if move == nil || state.game.Move(move) != nil {
+		return
+	}
+	fmt.Println("move", move.String())
+
+	switch state.game.Outcome() {
+	case chess.WhiteWon:
+		fmt.Println("1-0 {White mates}")
+	case chess.BlackWon:
+		fmt.Println("0-1 {Black mates}")
+	case chess.Draw:
+		fmt.Printf("1/2-1/2 {%s}\n", state.game.Method())
+	}
+}
+
+// replays the game without its last n moves
+func xboard_undo(state *xboard_state, n int) {
+	positions := state.game.Positions()
+	moves := state.game.Moves()
+	if n > len(moves) {
+		n = len(moves)
+	}
+	fen, _ := chess.FEN(positions[0].String())
+	game := new_uci_game(fen)
+	for _, move := range moves[:len(moves)-n] {
+		game.Move(move)
+	}
+	state.game = game
+}
+
+// base time is either "minutes" or "minutes:seconds"
+func xboard_parse_base(s string) time.Duration {
+	parts := strings.SplitN(s, ":", 2)
+	minutes, _ := strconv.Atoi(parts[0])
+	seconds := 0
+	if len(parts) == 2 {
+		seconds, _ = strconv.Atoi(parts[1])
+	}
+	return time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second
+}